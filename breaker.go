@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker, which stops the scrapes of the Management
+// Interface for a cool-down period after repeated failed scrapes.
+type breaker struct {
+	// threshold is the number of consecutive failures after which the
+	// breaker opens. The breaker is disabled when it's 0.
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether the Management Interface may be called.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+// record records the outcome of a scrape, and reports whether the breaker is
+// open afterwards. After the cool-down, a single failure opens the breaker
+// again.
+func (b *breaker) record(failed bool) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+	return time.Now().Before(b.openUntil)
+}
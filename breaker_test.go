@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(3, 50*time.Millisecond)
+	for i, failed := range []bool{true, true, false, true, true} {
+		if open := b.record(failed); open {
+			t.Fatalf("record %d: expected the breaker to stay closed", i)
+		}
+		if !b.allow() {
+			t.Fatalf("record %d: expected scrapes to be allowed", i)
+		}
+	}
+	if open := b.record(true); !open {
+		t.Fatal("expected the breaker to open after 3 consecutive failures")
+	}
+	if b.allow() {
+		t.Fatal("expected no scrapes while the breaker is open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("expected a scrape after the cool-down")
+	}
+	// A single failure after the cool-down opens the breaker again.
+	if open := b.record(true); !open || b.allow() {
+		t.Fatal("expected the breaker to open again after a failure")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if open := b.record(false); open || !b.allow() {
+		t.Fatal("expected the breaker to close after a success")
+	}
+	if open := b.record(true); open {
+		t.Fatal("expected the failures to be counted from 0 after a success")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		if open := b.record(true); open || !b.allow() {
+			t.Fatal("expected a disabled breaker never to open")
+		}
+	}
+}
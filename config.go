@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/processors"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the contents of the file passed with -config, e.g.
+//
+//	instances:
+//	  - name: registrar
+//	    protocol: mi_http
+//	    endpoint: http://127.0.0.1:8888/mi/
+//	    timeout: 2s
+//	    collect: ["core:", "usrloc:", "registrar:"]
+//	    labels:
+//	      role: registrar
+type config struct {
+	Instances []instanceConfig `yaml:"instances"`
+}
+
+// instanceConfig holds the settings of a single OpenSIPS instance.
+type instanceConfig struct {
+	// Name identifies the instance in /metrics?instance=<name> and in the
+	// instance label.
+	Name     string `yaml:"name"`
+	Protocol string `yaml:"protocol"`
+	// Endpoint is the address of the Management Interface, defaulting to the
+	// default of the address flag of the protocol. It's required for the auto
+	// protocol.
+	Endpoint string `yaml:"endpoint"`
+	// Format is the format used by the mi_datagram and mi_fifo protocols.
+	Format string `yaml:"format"`
+	// Timeout is how long to wait for a reply, defaulting to the
+	// -<protocol>_timeout flag.
+	Timeout time.Duration `yaml:"timeout"`
+	// Collect are the statistics groups collected when no collect[]
+	// parameters are given.
+	Collect []string `yaml:"collect"`
+	// Labels are added to every metric of the instance.
+	Labels map[string]string `yaml:"labels"`
+	// HTTP holds the authentication, TLS and header settings for the mi_http
+	// and mi_xmlrpc protocols.
+	HTTP opensips.HTTPConfig `yaml:"http"`
+	// FIFO holds the reply FIFO settings for the mi_fifo protocol,
+	// defaulting to the -fifo_reply_* flags.
+	FIFO opensips.FIFOConfig `yaml:"fifo"`
+	// DroutingPartitions are the partitions of the drouting module read with
+	// collect[]=drouting, defaulting to the -drouting_partitions flag.
+	DroutingPartitions []string `yaml:"drouting_partitions"`
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// loadConfig reads and validates the config file at path.
+func loadConfig(path string) (*config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := yaml.UnmarshalStrict(content, &c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if len(c.Instances) == 0 {
+		return nil, fmt.Errorf("no instances configured in %s", path)
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Instances {
+		instance := &c.Instances[i]
+		if instance.Name == "" {
+			return nil, fmt.Errorf("instance %d has no name", i+1)
+		}
+		if names[instance.Name] {
+			return nil, fmt.Errorf("instance %s is configured more than once", instance.Name)
+		}
+		names[instance.Name] = true
+
+		if instance.Protocol == opensips.AutoTransport {
+			if instance.Endpoint == "" {
+				return nil, fmt.Errorf("instance %s has protocol %s but no endpoint", instance.Name, instance.Protocol)
+			}
+		} else {
+			t, ok := opensips.Transports[instance.Protocol]
+			if !ok {
+				return nil, fmt.Errorf("instance %s has unknown protocol %q (%s)", instance.Name, instance.Protocol, strings.Join(append(opensips.TransportNames(), opensips.AutoTransport), ", "))
+			}
+			if instance.Endpoint == "" {
+				instance.Endpoint = t.Default
+			}
+		}
+		if instance.Format == "" {
+			instance.Format = opensips.FormatAuto.String()
+		}
+		if _, err := opensips.ParseFormat(instance.Format); err != nil {
+			return nil, fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+		if err := instance.HTTP.Validate(); err != nil {
+			return nil, fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+		if instance.FIFO.ReplyDir == "" {
+			instance.FIFO.ReplyDir = fifoConfig.ReplyDir
+		}
+		if instance.FIFO.ReplyMode == "" {
+			instance.FIFO.ReplyMode = fifoConfig.ReplyMode
+		}
+		if err := instance.FIFO.Validate(); err != nil {
+			return nil, fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+		if instance.DroutingPartitions == nil {
+			instance.DroutingPartitions = commandConfig.DroutingPartitions
+		}
+		for name := range instance.Labels {
+			if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+				return nil, fmt.Errorf("instance %s has invalid label name %q", instance.Name, name)
+			}
+			// The metrics that have the label already would keep their own
+			// value.
+			if name == "instance" || processors.IsLabelName(name) {
+				return nil, fmt.Errorf("instance %s has label %q, which is a label of the metrics of the exporter", instance.Name, name)
+			}
+		}
+	}
+	return &c, nil
+}
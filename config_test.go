@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigLabels(t *testing.T) {
+	tests := []struct {
+		label string
+		valid bool
+	}{
+		{"role", true},
+		{"datacenter", true},
+		{"instance", false},
+		{"module", false},
+		{"group", false},
+		{"set", false},
+		{"partition", false},
+		{"state", false},
+		{"le", false},
+		{"pid", false},
+		{"process", false},
+		{"__name__", false},
+		{"0role", false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			path := filepath.Join(dir, "config.yaml")
+			config := "instances:\n" +
+				"  - name: registrar\n" +
+				"    protocol: mi_http\n" +
+				"    endpoint: http://127.0.0.1:8888/mi/\n" +
+				"    labels:\n" +
+				"      " + tt.label + ": registrar\n"
+			if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadConfig(path)
+			if (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, got error %v", tt.valid, err)
+			}
+		})
+	}
+}
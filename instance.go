@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/processors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// instance is an OpenSIPS instance configured in the config file.
+type instance struct {
+	name    string
+	source  *instrumentedSource
+	collect []string
+	labels  map[string]string
+	// poller is set when the statistics are polled in the background.
+	poller *poller
+}
+
+// instances holds the instances of the config file, when one is used.
+var instances []*instance
+
+// newInstances creates the clients for the instances in c.
+func newInstances(c *config) ([]*instance, error) {
+	var result []*instance
+	for _, ic := range c.Instances {
+		// The format is validated when loading the config.
+		format, _ := opensips.ParseFormat(ic.Format)
+		timeout := ic.Timeout
+		if timeout == 0 {
+			timeout = transportTimeout(ic.Protocol)
+		}
+		source, err := newInstrumentedSource(ic.Protocol, opensips.Config{
+			Address: ic.Endpoint,
+			Format:  format,
+			Timeout: timeout,
+			HTTP:    ic.HTTP,
+			FIFO:    ic.FIFO,
+		}, processors.NewScrapeStats(), newBreaker(*breakerFailures, *breakerCooldown), processors.CommandConfig{
+			DroutingPartitions: ic.DroutingPartitions,
+		})
+		if err != nil {
+			for _, i := range result {
+				i.source.Close()
+			}
+			return nil, err
+		}
+		i := &instance{
+			name:    ic.Name,
+			source:  source,
+			collect: ic.Collect,
+			labels:  ic.Labels,
+		}
+		if *pollInterval > 0 {
+			i.poller = newPoller(source, defaultGroups(source, ic.Collect), *pollInterval)
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
+
+// instancesHandler serves the metrics of the instance given by the instance
+// query parameter, or of all instances with an instance label when it's not
+// given.
+func instancesHandler(w http.ResponseWriter, r *http.Request) {
+	selected := instances
+	name := r.URL.Query().Get("instance")
+	if name != "" {
+		selected = nil
+		for _, i := range instances {
+			if i.name == name {
+				selected = []*instance{i}
+			}
+		}
+		if selected == nil {
+			http.Error(w, "Unknown instance "+name, http.StatusNotFound)
+			return
+		}
+	}
+
+	for _, i := range selected {
+		if i.poller == nil {
+			continue
+		}
+		if err := i.poller.checkCollect(r); err != nil {
+			http.Error(w, fmt.Sprintf("Instance %s: %v", i.name, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The metrics of all instances need the same label names, so the labels an
+	// instance doesn't have are added with an empty value.
+	labelNames := make(map[string]bool)
+	for _, i := range selected {
+		for k := range i.labels {
+			labelNames[k] = true
+		}
+	}
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	gatherers := make(prometheus.Gatherers, len(selected)+1)
+	gatherers[0] = prometheus.DefaultGatherer
+	var wg sync.WaitGroup
+	for n, i := range selected {
+		labels := make(map[string]string)
+		for k := range labelNames {
+			labels[k] = i.labels[k]
+		}
+		if name == "" {
+			labels["instance"] = i.name
+		}
+		wg.Add(1)
+		go func(n int, i *instance) {
+			defer wg.Done()
+			var registry *prometheus.Registry
+			if i.poller != nil {
+				registry = i.poller.registry()
+			} else {
+				registry = scrape(ctx, i.source, collectGroups(r, i.source, i.collect))
+			}
+			gatherers[n+1] = labelGatherer{
+				gatherer: registry,
+				labels:   labels,
+			}
+		}(n, i)
+	}
+	wg.Wait()
+	serveMetrics(w, r, gatherers)
+}
+
+// labelGatherer adds labels to all metrics of gatherer. Labels the metrics
+// already have are left as is.
+type labelGatherer struct {
+	gatherer prometheus.Gatherer
+	labels   map[string]string
+}
+
+// Gather implements prometheus.Gatherer.
+func (g labelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.gatherer.Gather()
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			existing := make(map[string]bool)
+			for _, lp := range m.Label {
+				existing[lp.GetName()] = true
+			}
+			for name, value := range g.labels {
+				if existing[name] {
+					continue
+				}
+				name, value := name, value
+				m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+			}
+			sort.Sort(prometheus.LabelPairSorter(m.Label))
+		}
+	}
+	return mfs, err
+}
@@ -0,0 +1,146 @@
+package mock
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FIFO is a fake OpenSIPS mi_fifo FIFO. It reads requests like OpenSIPS < 3.0
+// (:command:reply_fifo followed by parameters up to an empty line) or like
+// OpenSIPS >= 3.0 (:reply_fifo:{...}), and only answers the ones in that
+// format.
+type FIFO struct {
+	response []byte
+	json     bool
+
+	dir  string
+	fifo *os.File
+	r    *bufio.Reader
+	// replyModes holds the permissions of the reply FIFOs answered.
+	replyModes []os.FileMode
+}
+
+// NewFIFO creates a new FIFO mock with given response. The FIFO and the reply
+// FIFOs live in the same directory.
+func NewFIFO(response []byte, json bool) (m *FIFO, err error) {
+	m = new(FIFO)
+	m.response = response
+	m.json = json
+	m.dir, err = ioutil.TempDir(os.TempDir(), "mock-opensips-")
+	if err != nil {
+		return
+	}
+	p := path.Join(m.dir, "mock_fifo")
+	err = syscall.Mkfifo(p, 0600)
+	if err != nil {
+		return
+	}
+	// Like OpenSIPS, keep the FIFO open for writing too so reads don't
+	// return EOF between requests.
+	m.fifo, err = os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	m.r = bufio.NewReader(m.fifo)
+	return
+}
+
+// Path returns the path of the FIFO.
+func (m *FIFO) Path() string {
+	return m.fifo.Name()
+}
+
+// ReplyDir returns the directory reply FIFOs should be created in.
+func (m *FIFO) ReplyDir() string {
+	return m.dir
+}
+
+// Run reads a given number of requests within the deadline, and answers the
+// ones in the expected format.
+func (m *FIFO) Run(count int, deadline time.Time) error {
+	err := m.fifo.SetReadDeadline(deadline)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; {
+		line, err := m.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" || !strings.HasPrefix(line, ":") {
+			// Empty lines are skipped, and so are lines that don't start a
+			// request, e.g. the parameters of a text request sent to
+			// OpenSIPS >= 3.0.
+			continue
+		}
+		i++
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			return fmt.Errorf("mock.run: malformed request %q", line)
+		}
+		var reply string
+		if m.json {
+			// :reply_fifo:{...}
+			if !strings.HasPrefix(fields[2], "{") {
+				continue
+			}
+			reply = fields[1]
+		} else {
+			// :command:reply_fifo, whatever follows it, with the
+			// parameters up to an empty line.
+			for {
+				param, err := m.r.ReadString('\n')
+				if err != nil {
+					return err
+				}
+				if param == "\n" {
+					break
+				}
+			}
+			reply = fields[2]
+		}
+		// OpenSIPS refuses reply FIFO names that could point elsewhere.
+		if strings.ContainsAny(reply, "./") {
+			continue
+		}
+		info, err := os.Stat(path.Join(m.dir, reply))
+		if err != nil {
+			return err
+		}
+		m.replyModes = append(m.replyModes, info.Mode().Perm())
+		c, err := os.OpenFile(path.Join(m.dir, reply), os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = c.Write(m.response)
+		if err != nil {
+			return err
+		}
+		err = c.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplyModes returns the permissions of the reply FIFOs answered by Run.
+func (m *FIFO) ReplyModes() []os.FileMode {
+	return m.replyModes
+}
+
+// Close removes the resources created for FIFO.
+func (m *FIFO) Close() error {
+	err := m.fifo.Close()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(m.dir)
+}
@@ -15,10 +15,12 @@ import (
 type Mock struct {
 	response []byte
 	sleep    time.Duration
+	split    int
 
 	dir  string
 	addr *net.UnixAddr
 	l    *net.UnixConn
+	udp  *net.UDPConn
 	g    errgroup.Group
 }
 
@@ -43,13 +45,52 @@ func New(response []byte, sleep time.Duration) (m *Mock, err error) {
 	return
 }
 
+// NewUDP creates a new Mock like New, listening on a UDP socket on localhost
+// instead of a Unix socket.
+func NewUDP(response []byte, sleep time.Duration) (m *Mock, err error) {
+	m = new(Mock)
+	m.response = response
+	m.sleep = sleep
+	m.udp, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	return
+}
+
+// Split makes the Mock send its response in datagrams of at most size bytes,
+// like OpenSIPS does for large replies.
+func (m *Mock) Split(size int) {
+	m.split = size
+}
+
+// datagrams returns the response split in datagrams.
+func (m *Mock) datagrams() [][]byte {
+	if m.split == 0 {
+		return [][]byte{m.response}
+	}
+	var datagrams [][]byte
+	for r := m.response; len(r) > 0; {
+		n := m.split
+		if n > len(r) {
+			n = len(r)
+		}
+		datagrams = append(datagrams, r[:n])
+		r = r[n:]
+	}
+	return datagrams
+}
+
 // Socket returns the Mock's socket address
 func (m *Mock) Socket() string {
+	if m.udp != nil {
+		return "udp://" + m.udp.LocalAddr().String()
+	}
 	return m.addr.Name
 }
 
 // Run handles a given number of requests within the deadline.
 func (m *Mock) Run(count int, deadline time.Time) error {
+	if m.udp != nil {
+		return m.runUDP(count, deadline)
+	}
 	err := m.l.SetReadDeadline(deadline)
 	if err != nil {
 		return err
@@ -70,9 +111,11 @@ func (m *Mock) Run(count int, deadline time.Time) error {
 			if err != nil {
 				return err
 			}
-			_, err = c.Write(m.response)
-			if err != nil {
-				return err
+			for _, d := range m.datagrams() {
+				_, err = c.Write(d)
+				if err != nil {
+					return err
+				}
 			}
 			return c.Close()
 		})
@@ -80,8 +123,36 @@ func (m *Mock) Run(count int, deadline time.Time) error {
 	return m.g.Wait()
 }
 
+func (m *Mock) runUDP(count int, deadline time.Time) error {
+	err := m.udp.SetReadDeadline(deadline)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		buf := make([]byte, 65535)
+		_, raddr, err := m.udp.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		m.g.Go(func() error {
+			time.Sleep(m.sleep)
+			for _, d := range m.datagrams() {
+				_, err := m.udp.WriteToUDP(d, raddr)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return m.g.Wait()
+}
+
 // Close removes the resources created for Mock.
 func (m *Mock) Close() error {
+	if m.udp != nil {
+		return m.udp.Close()
+	}
 	err := m.l.Close()
 	if err != nil {
 		return err
@@ -0,0 +1,198 @@
+package opensips
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// AutoTransport is the name of the protocol that detects which transport to
+// use, see AutoSource.
+const AutoTransport = "auto"
+
+// Candidate is a transport AutoSource tries to reach the Management Interface
+// with.
+type Candidate struct {
+	Protocol string
+	Config   Config
+}
+
+// Detected describes the Management Interface AutoSource found.
+type Detected struct {
+	Protocol string
+	Format   Format
+	// Version is the version of OpenSIPS (e.g. "2.4.5"), or empty when it
+	// couldn't be determined.
+	Version string
+}
+
+// AutoSource is a StatisticsSource that detects on the first call which of
+// the candidates reaches the Management Interface, and uses that one from
+// then on.
+type AutoSource struct {
+	candidates []Candidate
+
+	mu       sync.Mutex
+	source   StatisticsSource
+	detected Detected
+}
+
+// NewAutoSource creates an AutoSource trying the candidates in order. The
+// candidates have to implement Caller.
+func NewAutoSource(candidates []Candidate) *AutoSource {
+	return &AutoSource{
+		candidates: candidates,
+	}
+}
+
+// DefaultCandidates returns the candidates to try for address: mi_http for
+// URLs, mi_datagram in the JSON-RPC and then the text format otherwise. The
+// other settings are taken from c.
+func DefaultCandidates(address string, c Config) []Candidate {
+	c.Address = address
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		c.Format = FormatJSON
+		return []Candidate{{"mi_http", c}}
+	}
+	json, text := c, c
+	json.Format = FormatJSON
+	text.Format = FormatText
+	return []Candidate{{datagramTransport, json}, {datagramTransport, text}}
+}
+
+// GetStatistics implements StatisticsSource.
+func (a *AutoSource) GetStatistics(ctx context.Context, targets ...string) (Statistics, error) {
+	s, err := a.detect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetStatistics(ctx, targets...)
+}
+
+// Call implements Caller.
+func (a *AutoSource) Call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	s, err := a.detect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.(Caller).Call(ctx, method, params...)
+}
+
+// Detected returns the Management Interface that was detected, if any.
+func (a *AutoSource) Detected() (Detected, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.detected, a.source != nil
+}
+
+// Transport implements StatisticsSource. It returns the name of the detected
+// transport, or AutoTransport before detection.
+func (a *AutoSource) Transport() string {
+	if d, ok := a.Detected(); ok {
+		return d.Protocol
+	}
+	return AutoTransport
+}
+
+// Close implements StatisticsSource.
+func (a *AutoSource) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.source == nil {
+		return nil
+	}
+	return a.source.Close()
+}
+
+// detect returns the client for the detected Management Interface, trying
+// the candidates when that isn't known yet.
+func (a *AutoSource) detect(ctx context.Context) (StatisticsSource, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.source != nil {
+		return a.source, nil
+	}
+	var errs []string
+	for _, c := range a.candidates {
+		t, ok := Transports[c.Protocol]
+		if !ok {
+			continue
+		}
+		s, err := t.New(c.Config)
+		if err == nil {
+			var version string
+			version, err = probeVersion(ctx, s)
+			if err == nil {
+				a.source = s
+				a.detected = Detected{
+					Protocol: c.Protocol,
+					Format:   c.Config.Format,
+					Version:  version,
+				}
+				return s, nil
+			}
+			s.Close()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Sprintf("%s (%s): %v", c.Protocol, c.Config.Format, err))
+	}
+	return nil, fmt.Errorf("no Management Interface detected: %s", strings.Join(errs, ", "))
+}
+
+// probeVersion checks whether the Management Interface answers through s,
+// and returns the version of OpenSIPS. When OpenSIPS doesn't know the version
+// management function, which is called instead and the version is empty.
+func probeVersion(ctx context.Context, s StatisticsSource) (string, error) {
+	c, ok := s.(Caller)
+	if !ok {
+		return "", fmt.Errorf("%s can't call management functions", s.Transport())
+	}
+	reply, err := c.Call(ctx, "version")
+	var replyErr *ReplyError
+	if errors.As(err, &replyErr) {
+		_, err = c.Call(ctx, "which")
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+	return ServerVersion(reply), nil
+}
+
+var serverVersionRE = regexp.MustCompile(`\(([0-9][^ ()]*)`)
+
+// ServerVersion returns the version of OpenSIPS in a reply to the version
+// management function, e.g. "3.1.0" for {"Server": "OpenSIPS (3.1.0
+// (x86_64/linux))"}, or an empty string when it's not found.
+func ServerVersion(reply interface{}) string {
+	m := serverVersionRE.FindStringSubmatch(ReplyValue(reply, "Server"))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// ReplyValue returns the value of the top level field name in the reply to a
+// Call, both in the JSON-RPC and the text format, or an empty string when
+// there's no such field.
+func ReplyValue(reply interface{}, name string) string {
+	switch r := reply.(type) {
+	case map[string]interface{}:
+		if v, ok := r[name]; ok {
+			return fmt.Sprint(v)
+		}
+	case []interface{}:
+		for _, n := range r {
+			node, ok := n.(map[string]interface{})
+			if ok && node[TextNodeName] == name {
+				return fmt.Sprint(node[TextNodeValue])
+			}
+		}
+	}
+	return ""
+}
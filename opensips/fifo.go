@@ -0,0 +1,289 @@
+package opensips
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const fifoTransport = "mi_fifo"
+
+// FIFOReplyDir is the directory the mi_fifo module opens reply FIFOs in,
+// unless its reply_dir parameter is changed.
+const FIFOReplyDir = "/tmp/"
+
+// FIFOReplyMode are the default permissions of reply FIFOs: only the exporter
+// can read and write them, so OpenSIPS has to run as the same user (or root).
+const FIFOReplyMode os.FileMode = 0600
+
+// FIFOConfig holds the settings of the mi_fifo transport.
+type FIFOConfig struct {
+	// ReplyDir is the directory reply FIFOs are created in, which has to be
+	// the reply_dir parameter of mi_fifo. Defaults to FIFOReplyDir. It's
+	// created with mode 0700 when it doesn't exist. mi_fifo doesn't accept
+	// reply FIFOs in subdirectories of reply_dir, so use a directory only
+	// the exporter and OpenSIPS can access to keep replies private.
+	ReplyDir string `yaml:"reply_dir"`
+	// ReplyMode are the permissions of the reply FIFOs as an octal number,
+	// defaulting to FIFOReplyMode. When OpenSIPS runs as another user they
+	// have to be widened, e.g. to 0620 when that user is in the group of
+	// the exporter.
+	ReplyMode string `yaml:"reply_mode"`
+}
+
+// Validate checks the reply mode.
+func (c FIFOConfig) Validate() error {
+	_, err := c.replyMode()
+	return err
+}
+
+func (c FIFOConfig) replyDir() string {
+	if c.ReplyDir == "" {
+		return FIFOReplyDir
+	}
+	return c.ReplyDir
+}
+
+func (c FIFOConfig) replyMode() (os.FileMode, error) {
+	if c.ReplyMode == "" {
+		return FIFOReplyMode, nil
+	}
+	m, err := strconv.ParseUint(c.ReplyMode, 8, 32)
+	// The exporter has to be able to read the replies.
+	if err != nil || m&^0666 != 0 || m&0400 == 0 {
+		return 0, fmt.Errorf("invalid FIFO reply mode %q, expected octal permissions readable by the owner such as 0620", c.ReplyMode)
+	}
+	return os.FileMode(m), nil
+}
+
+// fifoPollInterval is how long to wait between checks whether OpenSIPS
+// started writing to the reply FIFO.
+const fifoPollInterval = 10 * time.Millisecond
+
+// FIFO holds all the information necessary for handling connections to the
+// OpenSIPS Management Interface through the mi_fifo module. It supports both
+// the line based format of OpenSIPS 1.x/2.x and the JSON-RPC format of
+// OpenSIPS >= 3.0.
+type FIFO struct {
+	fifo      string
+	replyDir  string
+	replyMode os.FileMode
+	// timeout is how long to wait for a reply.
+	timeout time.Duration
+	// observe is called with the duration of every call, when set.
+	observe func(command string, d time.Duration)
+
+	format int32
+	count  int64
+}
+
+// NewFIFO creates a new FIFO instance. Pass it the path of the running
+// OpenSIPS' mi_fifo FIFO and the directory mi_fifo opens reply FIFOs in (its
+// reply_dir parameter). The current user should have permissions to write to
+// the FIFO and to create files in the reply directory. The reply FIFOs are
+// created with FIFOReplyMode.
+func NewFIFO(fifo, replyDir string, format Format) *FIFO {
+	return &FIFO{
+		fifo:      fifo,
+		replyDir:  replyDir,
+		replyMode: FIFOReplyMode,
+		timeout:   DefaultTimeout,
+		format:    int32(format),
+	}
+}
+
+func init() {
+	Transports[fifoTransport] = Transport{
+		Flag:    "fifo",
+		Default: "/tmp/opensips_fifo",
+		Usage:   "Path to the mi_fifo FIFO of OpenSIPS. Replies are read from FIFOs created in -fifo_reply_dir.",
+		New: func(c Config) (StatisticsSource, error) {
+			mode, err := c.FIFO.replyMode()
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(c.FIFO.replyDir(), 0700); err != nil {
+				return nil, err
+			}
+			f := NewFIFO(c.Address, c.FIFO.replyDir(), c.Format)
+			f.replyMode = mode
+			if c.Timeout > 0 {
+				f.timeout = c.Timeout
+			}
+			f.observe = c.ObserveRoundtrip
+			return f, nil
+		},
+	}
+}
+
+// GetStatistics calls the get_statistics management function and returns the
+// statistics OpenSIPS sends back. The targets can be "all", "group:" or
+// "name" (e.g. "shmem:" or "rcv_requests").
+// When the format is not known yet, the JSON-RPC format is tried first, and the
+// format of the first successful call is used from then on.
+func (f *FIFO) GetStatistics(ctx context.Context, targets ...string) (Statistics, error) {
+	if f.observe != nil {
+		defer func(start time.Time) { f.observe("get_statistics", time.Since(start)) }(time.Now())
+	}
+	switch Format(atomic.LoadInt32(&f.format)) {
+	case FormatText:
+		return f.getTextStatistics(ctx, targets)
+	case FormatJSON:
+		return f.getJSONStatistics(ctx, targets, false)
+	}
+
+	// A reply with statistics that can't be parsed is still in the right
+	// format.
+	var parseErr *ParseError
+	statistics, err := f.getJSONStatistics(ctx, targets, true)
+	if err == nil || errors.As(err, &parseErr) {
+		atomic.StoreInt32(&f.format, int32(FormatJSON))
+		return statistics, err
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	statistics, textErr := f.getTextStatistics(ctx, targets)
+	if textErr != nil && !errors.As(textErr, &parseErr) {
+		return nil, fmt.Errorf("no reply in JSON-RPC (%v) or text format (%v)", err, textErr)
+	}
+	atomic.StoreInt32(&f.format, int32(FormatText))
+	return statistics, textErr
+}
+
+func (f *FIFO) getTextStatistics(ctx context.Context, targets []string) (Statistics, error) {
+	resp, err := f.roundtrip(ctx, func(reply string) ([]byte, error) {
+		// :get_statistics:reply_fifo followed by a parameter per line and an
+		// empty line.
+		msg := []byte(":get_statistics:" + reply + "\n")
+		for _, target := range targets {
+			msg = append(msg, []byte(target)...)
+			msg = append(msg, '\n')
+		}
+		return append(msg, '\n'), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseTextResponse(resp)
+}
+
+// getJSONStatistics requests the statistics in the JSON-RPC format. When probe
+// is set the format isn't known yet, and the request ends with an empty line:
+// OpenSIPS < 3.0 reads it as a call in the text format, with parameters up to
+// an empty line, and would otherwise take the next request as parameters.
+func (f *FIFO) getJSONStatistics(ctx context.Context, targets []string, probe bool) (Statistics, error) {
+	resp, err := f.roundtrip(ctx, func(reply string) ([]byte, error) {
+		// :reply_fifo: followed by the JSON-RPC request.
+		req, err := EncodeJSONRequest("get_statistics", targets)
+		if err != nil {
+			return nil, err
+		}
+		msg := []byte(":" + reply + ":")
+		msg = append(msg, req...)
+		msg = append(msg, '\n')
+		if probe {
+			msg = append(msg, '\n')
+		}
+		return msg, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, err := DecodeJSONResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSONStatistics(result)
+}
+
+// roundtrip creates a reply FIFO, writes the request built for it to the
+// OpenSIPS FIFO and returns everything OpenSIPS writes to the reply FIFO.
+// Waiting for the reply stops when ctx is done, or after the timeout if
+// that's earlier.
+func (f *FIFO) roundtrip(ctx context.Context, request func(reply string) ([]byte, error)) ([]byte, error) {
+	count := atomic.AddInt64(&f.count, 1)
+	name := fmt.Sprintf("opensips_exporter_%d_%d", os.Getpid(), count)
+	replyPath := path.Join(f.replyDir, name)
+	if err := syscall.Mkfifo(replyPath, 0600); err != nil {
+		return nil, err
+	}
+	defer os.Remove(replyPath)
+	// Only widen the permissions when configured to, e.g. for OpenSIPS
+	// running as another user.
+	if f.replyMode != 0600 {
+		if err := os.Chmod(replyPath, f.replyMode); err != nil {
+			return nil, err
+		}
+	}
+
+	// Open the reply FIFO before sending the request: OpenSIPS doesn't wait for
+	// a reader when opening it.
+	r, err := os.OpenFile(replyPath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	msg, err := request(name)
+	if err != nil {
+		return nil, err
+	}
+	// Don't block when OpenSIPS isn't reading its FIFO.
+	w, err := os.OpenFile(f.fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(msg)
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(f.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := r.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for {
+		_, err := buf.ReadFrom(r)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 {
+			// OpenSIPS closed the reply FIFO after writing the reply.
+			return buf.Bytes(), nil
+		}
+		// Reading without anyone having the FIFO open for writing returns
+		// EOF, so wait for OpenSIPS to open it.
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout while waiting for a reply on %s: %w", replyPath, os.ErrDeadlineExceeded)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(fifoPollInterval):
+		}
+	}
+}
+
+// Transport implements StatisticsSource.
+func (f *FIFO) Transport() string {
+	return fifoTransport
+}
+
+// Close implements StatisticsSource. Reply FIFOs are removed after every call,
+// so this is a no-op.
+func (f *FIFO) Close() error {
+	return nil
+}
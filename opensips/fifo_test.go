@@ -0,0 +1,123 @@
+package opensips_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/internal/mock"
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestFIFOGetStatistics(t *testing.T) {
+	var fakeStatisticObject = opensips.Statistic{
+		Name:   "fake_statistic",
+		Module: "core",
+		Value:  42,
+	}
+	tests := []struct {
+		name     string
+		response string
+		json     bool
+		// requests is the number of requests the mock receives, including
+		// the ones in the format it ignores.
+		requests int
+	}{
+		{"text", "200 OK\ncore:fake_statistic:: 42\n\n", false, 2},
+		{"json", `{"jsonrpc":"2.0","result":{"core:fake_statistic":42},"id":1}`, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := mock.NewFIFO([]byte(tt.response), tt.json)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := opensips.NewFIFO(m.Path(), m.ReplyDir(), opensips.FormatAuto)
+			var g errgroup.Group
+			g.Go(func() error {
+				statistics, err := f.GetStatistics(context.Background(), "fake_statistic")
+				if err != nil {
+					return err
+				}
+				if len(statistics) != 1 {
+					return fmt.Errorf("expected 1 statistic from GetStatistics, got %d", len(statistics))
+				}
+				if statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}] != fakeStatisticObject {
+					return fmt.Errorf("expected %v, got %v", fakeStatisticObject, statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}])
+				}
+				return nil
+			})
+			if err := m.Run(tt.requests, time.Now().Add(10*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFIFOReplyMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		expected os.FileMode
+	}{
+		{"default", "", 0600},
+		{"group", "0620", 0620},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := mock.NewFIFO([]byte(`{"jsonrpc":"2.0","result":{"core:fake_statistic":42},"id":1}`), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			s, err := opensips.Transports["mi_fifo"].New(opensips.Config{
+				Address: m.Path(),
+				Format:  opensips.FormatJSON,
+				FIFO:    opensips.FIFOConfig{ReplyDir: m.ReplyDir(), ReplyMode: tt.mode},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			var g errgroup.Group
+			g.Go(func() error {
+				_, err := s.GetStatistics(context.Background(), "fake_statistic")
+				return err
+			})
+			if err := m.Run(1, time.Now().Add(10*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if modes := m.ReplyModes(); len(modes) != 1 || modes[0] != tt.expected {
+				t.Errorf("expected reply FIFO mode %v, got %v", tt.expected, modes)
+			}
+		})
+	}
+}
+
+func TestFIFOConfigValidate(t *testing.T) {
+	for _, mode := range []string{"0600", "0620", "0622", ""} {
+		if err := (opensips.FIFOConfig{ReplyMode: mode}).Validate(); err != nil {
+			t.Errorf("expected mode %q to be valid, got %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"rw", "0777", "0200", "9"} {
+		if err := (opensips.FIFOConfig{ReplyMode: mode}).Validate(); err == nil {
+			t.Errorf("expected mode %q to be invalid", mode)
+		}
+	}
+}
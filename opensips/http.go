@@ -0,0 +1,127 @@
+package opensips
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPConfig holds the settings of the HTTP based transports, for reaching a
+// Management Interface behind e.g. a reverse proxy with authentication and
+// TLS. The secrets can be read from files, which are read again on every
+// request so they can be rotated.
+type HTTPConfig struct {
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	PasswordFile    string `yaml:"password_file"`
+	BearerToken     string `yaml:"bearer_token"`
+	BearerTokenFile string `yaml:"bearer_token_file"`
+	// CAFile is a PEM bundle of the CAs to verify the server certificate
+	// with, instead of the ones of the system.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate and key.
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// Headers are added to every request.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Validate checks whether the settings don't conflict.
+func (c HTTPConfig) Validate() error {
+	if c.Password != "" && c.PasswordFile != "" {
+		return errors.New("both a password and a password file are set")
+	}
+	if c.BearerToken != "" && c.BearerTokenFile != "" {
+		return errors.New("both a bearer token and a bearer token file are set")
+	}
+	if c.Username != "" && (c.BearerToken != "" || c.BearerTokenFile != "") {
+		return errors.New("both basic authentication and a bearer token are set")
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("a client certificate needs both a certificate and a key file")
+	}
+	return nil
+}
+
+// Client returns an HTTP client for the settings, which times out after
+// timeout unless it's zero.
+func (c HTTPConfig) Client(timeout time.Duration) (*http.Client, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: &httpRoundTripper{config: c, next: transport},
+		Timeout:   timeout,
+	}, nil
+}
+
+// httpRoundTripper adds the authentication and extra headers to requests.
+type httpRoundTripper struct {
+	config HTTPConfig
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *httpRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper mustn't modify the request.
+	req = req.Clone(req.Context())
+	for name, value := range rt.config.Headers {
+		req.Header.Set(name, value)
+	}
+	if rt.config.Username != "" {
+		password, err := secret(rt.config.Password, rt.config.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the password file: %w", err)
+		}
+		req.SetBasicAuth(rt.config.Username, password)
+	}
+	if rt.config.BearerToken != "" || rt.config.BearerTokenFile != "" {
+		token, err := secret(rt.config.BearerToken, rt.config.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the bearer token file: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// secret returns value, or the contents of file without trailing newlines
+// when it's set.
+func secret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
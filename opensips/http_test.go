@@ -0,0 +1,76 @@
+package opensips_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+)
+
+func TestHTTPConfigClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opensips-http-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := path.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "exporter" || password != "secret" {
+			t.Errorf("expected basic authentication as exporter:secret, got %q", r.Header.Get("Authorization"))
+		}
+		if v := r.Header.Get("X-Scope"); v != "opensips" {
+			t.Errorf("expected X-Scope header opensips, got %q", v)
+		}
+	}))
+	defer s.Close()
+	caFile := path.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := opensips.HTTPConfig{
+		Username:     "exporter",
+		PasswordFile: passwordFile,
+		CAFile:       caFile,
+		Headers:      map[string]string{"X-Scope": "opensips"},
+	}.Client(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Without the CA the server certificate can't be verified.
+	c, err = opensips.HTTPConfig{}.Client(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(s.URL); err == nil {
+		t.Fatal("expected the server certificate not to be trusted")
+	}
+}
+
+func TestHTTPConfigValidate(t *testing.T) {
+	for _, c := range []opensips.HTTPConfig{
+		{Username: "exporter", Password: "secret", PasswordFile: "/run/secrets/password"},
+		{Username: "exporter", BearerToken: "token"},
+		{CertFile: "client.pem"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+}
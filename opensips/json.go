@@ -0,0 +1,93 @@
+package opensips
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/KeisukeYamashita/go-jsonrpc"
+)
+
+// EncodeJSONRequest encodes a JSON-RPC request as understood by the
+// Management Interface of OpenSIPS >= 3.0.
+func EncodeJSONRequest(method string, params ...interface{}) ([]byte, error) {
+	r := jsonrpc.RPCRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		ID:      1,
+	}
+	// Leave out the params instead of sending null when there are none.
+	if len(params) > 0 {
+		r.Params = params
+	}
+	return json.Marshal(r)
+}
+
+// DecodeJSONResponse decodes a JSON-RPC response and returns its result. Numbers
+// are decoded as json.Number. A JSON-RPC error is returned as a *ReplyError.
+func DecodeJSONResponse(response []byte) (interface{}, error) {
+	var r jsonrpc.RPCResponse
+	d := json.NewDecoder(bytes.NewReader(response))
+	// Keep numbers as they were sent, ParseJSONStatistics parses them.
+	d.UseNumber()
+	if err := d.Decode(&r); err != nil {
+		return nil, fmt.Errorf("error while decoding JSON-RPC response: %w", err)
+	}
+	if r.Error != nil {
+		return nil, &ReplyError{Code: r.Error.Code, Message: r.Error.Message}
+	}
+	return r.Result, nil
+}
+
+// ParseJSONStatistics parses the result of a get_statistics JSON-RPC call
+// (OpenSIPS >= 3.0), e.g. {"core:rcv_requests": 42}. When some of the
+// statistics can't be parsed, the others are returned along with a
+// *ParseError.
+func ParseJSONStatistics(result interface{}) (Statistics, error) {
+	response, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected get_statistics result: %v", result)
+	}
+	var res = Statistics{}
+	var parseErr ParseError
+	for key, value := range response {
+		asString := fmt.Sprintf("%s = %s", key, value)
+		stat, err := parseJSONStatistic(asString)
+		if err != nil {
+			parseErr.add(statisticModule(key), err)
+			continue
+		}
+		res.Add(stat)
+	}
+	return res, parseErr.errorOrNil()
+}
+
+func parseJSONStatistic(metric string) (Statistic, error) {
+	var name, module, valueString string
+	if metric == "" {
+		return Statistic{}, nil
+	}
+	if strings.Contains(metric, "=") {
+		// i.e. shmem:total_size = 2147483648
+		metricSplit := strings.Split(metric, ":")
+		module = metricSplit[0]
+		name = strings.Split(strings.Join(metricSplit[1:], ":"), " ")[0]
+		i := strings.LastIndex(metric, " ")
+		valueString = metric[i+1:]
+	} else {
+		return Statistic{}, fmt.Errorf("unknown metric format encountered for: %s", metric)
+	}
+
+	value, err := strconv.ParseFloat(valueString, 64)
+	if err != nil {
+		return Statistic{}, err
+	}
+
+	return Statistic{
+		Module: module,
+		Name:   name,
+		Value:  value,
+	}, nil
+}
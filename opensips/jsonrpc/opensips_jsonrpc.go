@@ -1,90 +1,108 @@
 package jsonrpc
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"strconv"
-	"strings"
+	"io/ioutil"
+	"net/http"
+	"time"
 
-	"github.com/KeisukeYamashita/go-jsonrpc"
 	"github.com/VoIPGRID/opensips_exporter/opensips"
 )
 
+const transport = "mi_http"
+
 // JSONRPC holds all the information necessary for handling connections to
 // the OpenSIPS Management Interface (targeting version >= 3.0).
 type JSONRPC struct {
-	url string
+	url    string
+	client *http.Client
+	// observe is called with the duration of every call, when set.
+	observe func(command string, d time.Duration)
 }
 
 // New creates a new JSONRPC instance. Pass it the running OpenSIPS'
 // HTTP JSON RPC endpoint to connect to.
 func New(url string) *JSONRPC {
 	return &JSONRPC{
-		url: url,
+		url:    url,
+		client: &http.Client{},
+	}
+}
+
+func init() {
+	opensips.Transports[transport] = opensips.Transport{
+		Flag:    "http_address",
+		Default: "http://127.0.0.1:8888/mi/",
+		Usage:   "Address to query the Management Interface through HTTP with (e.g. http://127.0.0.1:8888/mi/)",
+		New: func(c opensips.Config) (opensips.StatisticsSource, error) {
+			o := New(c.Address)
+			client, err := c.HTTP.Client(c.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			o.client = client
+			o.observe = c.ObserveRoundtrip
+			return o, nil
+		},
 	}
 }
 
 // GetStatistics calls the JSON-RPC endpoint and returns the
 // statistics OpenSIPS sends back. The targets can be "all", "group:" or
 // "name" (e.g. "shmem:" or "rcv_requests").
-func (o *JSONRPC) GetStatistics(targets ...string) (map[string]opensips.Statistic, error) {
-	rpcClient := jsonrpc.NewRPCClient(o.url)
-
+func (o *JSONRPC) GetStatistics(ctx context.Context, targets ...string) (opensips.Statistics, error) {
 	// request {"jsonrpc":"2.0","method":"get_statistics","params":[["core:","tm:"]],"id":1}
-	resp, err := rpcClient.Call("get_statistics", targets)
+	result, err := o.Call(ctx, "get_statistics", targets)
 	if err != nil {
 		return nil, fmt.Errorf("error while getting statistics from JSON-RPC endpoint: %w", err)
 	}
 
-	statistics, err := parseStatistics(resp.Result.(map[string]interface{}))
-	if err != nil {
-		return nil, err
-	}
-
-	return statistics, nil
+	return opensips.ParseJSONStatistics(result)
 }
 
-func parseStatistics(response map[string]interface{}) (map[string]opensips.Statistic, error) {
-	var res = map[string]opensips.Statistic{}
-	for key, value := range response {
-		asString := fmt.Sprintf("%s = %s", key, value)
-		stat, err := parseStatistic(asString)
-		if err != nil {
-			return res, fmt.Errorf("error while parsing stat: %w", err)
-		}
-		res[stat.Name] = stat
+// Call calls the management function method with the given positional
+// parameters and returns the result as decoded from JSON, with numbers as
+// json.Number.
+func (o *JSONRPC) Call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	if o.observe != nil {
+		defer func(start time.Time) { o.observe(method, time.Since(start)) }(time.Now())
 	}
-	return res, nil
-}
-
-func parseStatistic(metric string) (opensips.Statistic, error) {
-	var name, module, valueString string
-	// Check for OpenSIPS >= 2 metric format
-	// i.e.shmem:total_size:: 2147483648
-	if metric == "" {
-		// There's an empty line in the output since OpenSIPS 2.4.5
-		// ignore and continue
-		return opensips.Statistic{}, nil
+	body, err := opensips.EncodeJSONRequest(method, params...)
+	if err != nil {
+		return nil, err
 	}
-	if strings.Contains(metric, "=") {
-		// OpenSIPS < 2 metric format
-		// i.e. shmem:total_size = 2147483648
-		metricSplit := strings.Split(metric, ":")
-		module = metricSplit[0]
-		name = strings.Split(strings.Join(metricSplit[1:], ":"), " ")[0]
-		i := strings.LastIndex(metric, " ")
-		valueString = metric[i+1:]
-	} else {
-		return opensips.Statistic{}, fmt.Errorf("unknown metric format encountered for: %s", metric)
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
 
-	value, err := strconv.ParseFloat(valueString, 64)
+	resp, err := o.client.Do(req)
 	if err != nil {
-		return opensips.Statistic{}, err
+		return nil, err
 	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return opensips.DecodeJSONResponse(b)
+}
+
+// Transport implements opensips.StatisticsSource.
+func (o *JSONRPC) Transport() string {
+	return transport
+}
 
-	return opensips.Statistic{
-		Module: module,
-		Name:   name,
-		Value:  value,
-	}, nil
+// Close implements opensips.StatisticsSource. The JSON-RPC client holds no
+// resources, so this is a no-op.
+func (o *JSONRPC) Close() error {
+	return nil
 }
@@ -0,0 +1,184 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/opensips/jsonrpc"
+)
+
+// server returns a stand-in for mi_http which expects a call of method with
+// the given parameters, and answers it with the given HTTP status and
+// response.
+func server(t *testing.T, method string, params []interface{}, status int, response string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.Method != http.MethodPost {
+			t.Errorf("expected a POST request, got %s", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected Content-Type application/json, got %q", ct)
+		}
+		var call struct {
+			JSONRPC string        `json:"jsonrpc"`
+			Method  string        `json:"method"`
+			Params  []interface{} `json:"params"`
+			ID      int           `json:"id"`
+		}
+		if err := json.Unmarshal(body, &call); err != nil {
+			t.Errorf("invalid request %q: %v", body, err)
+		}
+		if call.JSONRPC != "2.0" {
+			t.Errorf("expected a JSON-RPC 2.0 request, got %q", body)
+		}
+		if call.Method != method {
+			t.Errorf("expected %s to be called, got %q", method, call.Method)
+		}
+		if !reflect.DeepEqual(call.Params, params) {
+			t.Errorf("expected parameters %v, got %v", params, call.Params)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   []interface{}
+		response string
+		expected interface{}
+	}{
+		{
+			name:     "without parameters",
+			response: `{"jsonrpc":"2.0","result":{"Server":"OpenSIPS (3.4.0 (x86_64/linux))"},"id":1}`,
+			expected: map[string]interface{}{"Server": "OpenSIPS (3.4.0 (x86_64/linux))"},
+		},
+		{
+			name:     "with parameters",
+			params:   []interface{}{"partition_name"},
+			response: `{"jsonrpc":"2.0","result":{"Gateways":[{"ID":"gw1","State":"Active","Weight":2}]},"id":1}`,
+			expected: map[string]interface{}{"Gateways": []interface{}{
+				map[string]interface{}{"ID": "gw1", "State": "Active", "Weight": json.Number("2")},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server(t, "dr_gw_status", tt.params, http.StatusOK, tt.response)
+			defer s.Close()
+
+			result, err := jsonrpc.New(s.URL).Call(context.Background(), "dr_gw_status", tt.params...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %#v, got %#v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		// replyError is the expected *opensips.ReplyError, when the error
+		// is one.
+		replyError *opensips.ReplyError
+	}{
+		{
+			name:       "method not found",
+			status:     http.StatusOK,
+			response:   `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":1}`,
+			replyError: &opensips.ReplyError{Code: -32601, Message: "Method not found"},
+		},
+		{
+			name:       "command error",
+			status:     http.StatusOK,
+			response:   `{"jsonrpc":"2.0","error":{"code":500,"message":"Unknown partition"},"id":1}`,
+			replyError: &opensips.ReplyError{Code: 500, Message: "Unknown partition"},
+		},
+		{
+			name:     "http status",
+			status:   http.StatusInternalServerError,
+			response: `Internal Server Error`,
+		},
+		{
+			name:     "invalid json",
+			status:   http.StatusOK,
+			response: `{"jsonrpc":"2.0","result":`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server(t, "dr_gw_status", nil, tt.status, tt.response)
+			defer s.Close()
+
+			_, err := jsonrpc.New(s.URL).Call(context.Background(), "dr_gw_status")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var replyErr *opensips.ReplyError
+			if errors.As(err, &replyErr) != (tt.replyError != nil) {
+				t.Fatalf("expected reply error %v, got %v", tt.replyError, err)
+			}
+			if tt.replyError != nil && *replyErr != *tt.replyError {
+				t.Errorf("expected reply error %v, got %v", tt.replyError, replyErr)
+			}
+		})
+	}
+}
+
+func TestGetStatistics(t *testing.T) {
+	params := []interface{}{[]interface{}{"core:", "shmem:"}}
+	s := server(t, "get_statistics", params, http.StatusOK,
+		`{"jsonrpc":"2.0","result":{"core:rcv_requests":42,"shmem:total_size":2147483648},"id":1}`)
+	defer s.Close()
+
+	statistics, err := jsonrpc.New(s.URL).GetStatistics(context.Background(), "core:", "shmem:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []opensips.Statistic{
+		{Module: "core", Name: "rcv_requests", Value: 42},
+		{Module: "shmem", Name: "total_size", Value: 2147483648},
+	}
+	if len(statistics) != len(expected) {
+		t.Fatalf("expected %d statistics, got %d: %v", len(expected), len(statistics), statistics)
+	}
+	for _, stat := range expected {
+		if got, _ := statistics.Get(stat.Module, stat.Name); got != stat {
+			t.Errorf("expected %v, got %v", stat, got)
+		}
+	}
+}
+
+func TestGetStatisticsReplyError(t *testing.T) {
+	params := []interface{}{[]interface{}{"foo:"}}
+	s := server(t, "get_statistics", params, http.StatusOK,
+		`{"jsonrpc":"2.0","error":{"code":404,"message":"Statistics Not Found"},"id":1}`)
+	defer s.Close()
+
+	_, err := jsonrpc.New(s.URL).GetStatistics(context.Background(), "foo:")
+	var replyErr *opensips.ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != 404 {
+		t.Fatalf("expected a reply error with code 404, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Statistics Not Found") {
+		t.Errorf("expected the message of the reply in the error, got %v", err)
+	}
+}
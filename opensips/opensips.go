@@ -2,27 +2,56 @@ package opensips
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const firstLineOK = "200 OK\n"
 
+const datagramTransport = "mi_datagram"
+
+// datagramReplySize is the size of the buffer mi_datagram writes replies to.
+// A datagram of this size means the reply continues in the next datagram.
+const datagramReplySize = 65457
+
+// ErrTruncated is returned when the reply of OpenSIPS is incomplete.
+var ErrTruncated = errors.New("mi_datagram reply truncated")
+
+// udpPrefix marks mi_datagram addresses that are UDP sockets.
+const udpPrefix = "udp://"
+
 // OpenSIPS holds all the information necessary for handling connections to
-// the OpenSIPS Management Interface (targeting version 1.10).
+// the OpenSIPS Management Interface through the mi_datagram module. It
+// supports both the line based format of OpenSIPS 1.x/2.x (targeting version
+// 1.10) and the JSON-RPC format of OpenSIPS >= 3.0.
 type OpenSIPS struct {
 	socket string
 	tmpdir string
+	// lock is locked while tmpdir is in use, see RemoveStaleDirs.
+	lock *os.File
+	// udpAddr is the host:port mi_datagram listens on, when it's bound to a
+	// UDP socket instead of a Unix socket.
+	udpAddr string
 
-	count int64
+	// timeout is how long to wait for a reply.
+	timeout time.Duration
+	// observe is called with the duration of every call, when set.
+	observe func(command string, d time.Duration)
+
+	format int32
+	count  int64
 }
 
 // Statistic holds the module, name and value of a statistic
@@ -37,37 +66,219 @@ type Statistic struct {
 // expressed as a full path to the socket, and the current user should have
 // permissions to read from and write to this socket, in addition to write
 // access to the folder it's located in (for creating the return socket).
-func New(socket string) (*OpenSIPS, error) {
-	tmpdir, err := ioutil.TempDir(path.Dir(socket), "opensips_exporter")
+// When mi_datagram is bound to a UDP socket, pass it as udp://host:port
+// instead.
+// With FormatAuto the format is detected on the first call.
+func New(socket string, format Format) (*OpenSIPS, error) {
+	if strings.HasPrefix(socket, udpPrefix) {
+		udpAddr := strings.TrimPrefix(socket, udpPrefix)
+		if _, _, err := net.SplitHostPort(udpAddr); err != nil {
+			return nil, fmt.Errorf("invalid mi_datagram UDP address %q: %w", socket, err)
+		}
+		return &OpenSIPS{
+			socket:  socket,
+			udpAddr: udpAddr,
+			timeout: DefaultTimeout,
+			format:  int32(format),
+		}, nil
+	}
+	tmpdir, err := ioutil.TempDir(path.Dir(socket), tmpdirPrefix)
 	if err != nil {
 		return nil, err
 	}
+	lock, err := lockDir(tmpdir)
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, err
+	}
 	return &OpenSIPS{
-		socket: socket,
-		tmpdir: tmpdir,
+		socket:  socket,
+		tmpdir:  tmpdir,
+		lock:    lock,
+		timeout: DefaultTimeout,
+		format:  int32(format),
 	}, nil
 }
 
+// tmpdirPrefix is the prefix of the directories the reply sockets are created
+// in, next to the mi_datagram socket.
+const tmpdirPrefix = "opensips_exporter"
+
+// lockFile is the name of the file in a reply socket directory that is locked
+// while the directory is in use.
+const lockFile = "lock"
+
+// lockDir creates the lock file in dir and locks it. The lock is released
+// when the file is closed, or when the process exits.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(path.Join(dir, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking %s: %w", f.Name(), err)
+	}
+	return f, nil
+}
+
+// staleDirAge is how old a reply socket directory without lock file has to be
+// to be considered stale. Older versions created one for every scrape.
+const staleDirAge = time.Minute
+
+// RemoveStaleDirs removes the reply socket directories next to the
+// mi_datagram socket that were left behind by exporters that didn't exit
+// cleanly, and returns their paths. Directories still in use by a running
+// exporter are kept.
+func RemoveStaleDirs(socket string) ([]string, error) {
+	if strings.HasPrefix(socket, udpPrefix) {
+		return nil, nil
+	}
+	dirs, err := filepath.Glob(path.Join(path.Dir(socket), tmpdirPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if !dirStale(dir, info) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
+
+// dirStale reports whether the reply socket directory dir is no longer used.
+func dirStale(dir string, info os.FileInfo) bool {
+	f, err := os.Open(path.Join(dir, lockFile))
+	if os.IsNotExist(err) {
+		return time.Since(info.ModTime()) > staleDirAge
+	}
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	// The lock can only be taken when the exporter that held it is gone.
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil
+}
+
 // GetStatistics calls the get_statistics management function and returns the
 // statistics OpenSIPS sends back. The targets can be "all", "group:" or
 // "name" (e.g. "shmem:" or "rcv_requests").
-func (o *OpenSIPS) GetStatistics(targets ...string) (map[string]Statistic, error) {
-	msg := []byte(":get_statistics:\n")
+// When the reply to all targets doesn't fit in the datagrams mi_datagram
+// sends, the targets are requested one by one and the results are merged.
+func (o *OpenSIPS) GetStatistics(ctx context.Context, targets ...string) (Statistics, error) {
+	statistics, err := o.getStatistics(ctx, targets)
+	if !errors.Is(err, ErrTruncated) || len(targets) < 2 {
+		return statistics, err
+	}
+	statistics = Statistics{}
+	var parseErr ParseError
 	for _, target := range targets {
-		msg = append(msg, []byte(target)...)
-		msg = append(msg, '\n')
+		s, err := o.getStatistics(ctx, []string{target})
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			parseErr.merge(perr)
+		} else if err != nil {
+			return nil, fmt.Errorf("error while getting statistics for %s: %w", target, err)
+		}
+		statistics.Merge(s)
 	}
-	resp, err := o.roundtrip(msg)
+	return statistics, parseErr.errorOrNil()
+}
+
+func (o *OpenSIPS) getStatistics(ctx context.Context, targets []string) (Statistics, error) {
+	// The text format takes a target per line, JSON-RPC a list of targets:
+	// {"jsonrpc":"2.0","method":"get_statistics","params":[["core:","tm:"]],"id":1}
+	resp, format, err := o.request(ctx, "get_statistics", targets, []interface{}{targets})
 	if err != nil {
 		return nil, err
 	}
+	if format == FormatText {
+		return parseTextResponse(resp)
+	}
+	result, err := DecodeJSONResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSONStatistics(result)
+}
+
+// Call calls the management function method and returns the reply of
+// OpenSIPS as a tree of maps, slices and scalars. The reply to a JSON-RPC call
+// (OpenSIPS >= 3.0) is returned as decoded from JSON, with numbers as
+// json.Number. A reply in the text format is returned as a slice of nodes,
+// see TextNodeName.
+// The params are passed positionally, in the text format as a line each.
+func (o *OpenSIPS) Call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	var textParams []string
+	for _, p := range params {
+		textParams = append(textParams, fmt.Sprint(p))
+	}
+	resp, format, err := o.request(ctx, method, textParams, params)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatText {
+		return parseTextTree(resp)
+	}
+	return DecodeJSONResponse(resp)
+}
+
+// request sends the management function call in the format OpenSIPS
+// understands, detecting it first if needed, and returns the raw reply and the
+// format it's in.
+func (o *OpenSIPS) request(ctx context.Context, method string, textParams []string, jsonParams []interface{}) ([]byte, Format, error) {
+	if o.observe != nil {
+		defer func(start time.Time) { o.observe(method, time.Since(start)) }(time.Now())
+	}
+	format := Format(atomic.LoadInt32(&o.format))
+	if format != FormatJSON {
+		msg := []byte(":" + method + ":\n")
+		for _, p := range textParams {
+			msg = append(msg, []byte(p)...)
+			msg = append(msg, '\n')
+		}
+		resp, err := o.roundtrip(ctx, msg)
+		if err != nil {
+			return nil, format, err
+		}
+		if format == FormatText {
+			return resp, FormatText, nil
+		}
+		if !bytes.HasPrefix(bytes.TrimSpace(resp), []byte("{")) {
+			atomic.StoreInt32(&o.format, int32(FormatText))
+			return resp, FormatText, nil
+		}
+		// OpenSIPS >= 3.0 answers requests in the text format with a
+		// JSON-RPC parse error.
+		atomic.StoreInt32(&o.format, int32(FormatJSON))
+	}
+	msg, err := EncodeJSONRequest(method, jsonParams...)
+	if err != nil {
+		return nil, FormatJSON, err
+	}
+	resp, err := o.roundtrip(ctx, msg)
+	return resp, FormatJSON, err
+}
+
+// parseTextResponse parses the reply to get_statistics in the line based
+// format of OpenSIPS < 3.0.
+func parseTextResponse(resp []byte) (Statistics, error) {
 	buf := bytes.NewBuffer(resp)
 	line, err := buf.ReadString('\n')
 	if err != nil {
 		return nil, err
 	}
 	if line != firstLineOK {
-		return nil, fmt.Errorf("expected %q, got %q", firstLineOK, line)
+		return nil, textReplyError(line)
 	}
 	var rv []string
 	for err == nil {
@@ -75,25 +286,103 @@ func (o *OpenSIPS) GetStatistics(targets ...string) (map[string]Statistic, error
 		line, err = buf.ReadString('\n')
 	}
 
-	statistics, err := parseStatistics(rv[1:])
-	if err != nil {
-		return nil, fmt.Errorf("error while parsing statistics: %v", err)
+	return ParseStatistics(rv[1:])
+}
+
+// textReplyError returns the error for the first line of a reply in the line
+// based format that isn't "200 OK", e.g. a *ReplyError for
+// "500 command 'foo' not available".
+func textReplyError(line string) error {
+	line = strings.TrimSuffix(line, "\n")
+	fields := strings.SplitN(line, " ", 2)
+	if code, err := strconv.Atoi(fields[0]); err == nil && len(fields) == 2 {
+		return &ReplyError{Code: code, Message: fields[1]}
 	}
+	return fmt.Errorf("expected %q, got %q", firstLineOK, line)
+}
 
-	return statistics, nil
+// ParseError is returned along with the statistics that could be parsed, when
+// some of the statistics in a reply couldn't be parsed.
+type ParseError struct {
+	// Modules holds the number of statistics that couldn't be parsed per
+	// module.
+	Modules map[string]int
+	// Err is the first error encountered.
+	Err error
 }
 
-func parseStatistics(statistics []string) (map[string]Statistic, error) {
-	var res = map[string]Statistic{}
+func (e *ParseError) Error() string {
+	var count int
+	for _, n := range e.Modules {
+		count += n
+	}
+	return fmt.Sprintf("error while parsing %d statistics: %v", count, e.Err)
+}
+
+// Unwrap returns the first error encountered.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// add records that a statistic of module couldn't be parsed.
+func (e *ParseError) add(module string, err error) {
+	if e.Modules == nil {
+		e.Modules = make(map[string]int)
+	}
+	e.Modules[module]++
+	if e.Err == nil {
+		e.Err = err
+	}
+}
+
+// merge adds the statistics of other that couldn't be parsed to e.
+func (e *ParseError) merge(other *ParseError) {
+	for module, n := range other.Modules {
+		for i := 0; i < n; i++ {
+			e.add(module, other.Err)
+		}
+	}
+}
+
+// errorOrNil returns e as an error, or nil when nothing went wrong.
+func (e *ParseError) errorOrNil() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e
+}
+
+// statisticModule returns the module of an unparsed statistic, e.g. "shmem"
+// for "shmem:total_size = 2147483648".
+func statisticModule(metric string) string {
+	if i := strings.Index(metric, ":"); i >= 0 {
+		return strings.TrimSpace(metric[:i])
+	}
+	return ""
+}
+
+// ParseStatistics parses statistics in the line based format of OpenSIPS < 3.0
+// (e.g. "shmem:total_size = 2147483648" or "shmem:total_size:: 2147483648").
+// When some of the statistics can't be parsed, the others are returned along
+// with a *ParseError.
+func ParseStatistics(statistics []string) (Statistics, error) {
+	var res = Statistics{}
+	var parseErr ParseError
 	for _, s := range statistics {
 		s = strings.TrimSuffix(s, "\n")
+		if s == "" {
+			// There's an empty line in the output since OpenSIPS 2.4.5, and
+			// mi_fifo ends its replies with one.
+			continue
+		}
 		stat, err := parseStatistic(s)
 		if err != nil {
-			return res, err
+			parseErr.add(statisticModule(s), err)
+			continue
 		}
-		res[stat.Name] = stat
+		res.Add(stat)
 	}
-	return res, nil
+	return res, parseErr.errorOrNil()
 }
 
 func parseStatistic(metric string) (Statistic, error) {
@@ -135,7 +424,10 @@ func parseStatistic(metric string) (Statistic, error) {
 	}, nil
 }
 
-func (o *OpenSIPS) roundtrip(request []byte) ([]byte, error) {
+func (o *OpenSIPS) roundtrip(ctx context.Context, request []byte) ([]byte, error) {
+	if o.udpAddr != "" {
+		return o.roundtripUDP(ctx, request)
+	}
 	raddr, err := net.ResolveUnixAddr("unixgram", o.socket)
 	if err != nil {
 		return nil, err
@@ -155,20 +447,113 @@ func (o *OpenSIPS) roundtrip(request []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = c.SetReadDeadline(time.Now().Add(time.Second))
+	return readReply(ctx, c, o.timeout)
+}
+
+func (o *OpenSIPS) roundtripUDP(ctx context.Context, request []byte) ([]byte, error) {
+	// OpenSIPS replies from the socket the request was sent to, so a
+	// connected socket receives the reply.
+	c, err := net.Dial("udp", o.udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	_, err = c.Write(request)
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, 65535)
-	n, err := c.Read(buf)
+	return readReply(ctx, c, o.timeout)
+}
+
+// readReply reads the reply OpenSIPS sends back to c. Replies that don't fit
+// in the reply buffer of mi_datagram are sent in multiple datagrams, which
+// are reassembled. If the reply isn't complete before the deadline,
+// ErrTruncated is returned. Reading stops at the deadline of ctx, or after
+// timeout if that's earlier.
+func readReply(ctx context.Context, c net.Conn, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	err := c.SetReadDeadline(deadline)
 	if err != nil {
 		return nil, err
 	}
-	return buf[:n], err
+	// Interrupt the read when ctx is cancelled.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var reply []byte
+	buf := make([]byte, 65535)
+	for {
+		n, err := readDatagram(c, buf)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			var netErr net.Error
+			if len(reply) > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, fmt.Errorf("%w: no more data after %d bytes", ErrTruncated, len(reply))
+			}
+			return nil, err
+		}
+		reply = append(reply, buf[:n]...)
+		if n < datagramReplySize && replyComplete(reply) {
+			return reply, nil
+		}
+	}
+}
+
+// readDatagram reads a single datagram from c, and returns ErrTruncated if it
+// didn't fit in buf.
+func readDatagram(c net.Conn, buf []byte) (int, error) {
+	var n, flags int
+	var err error
+	switch c := c.(type) {
+	case *net.UnixConn:
+		n, _, flags, _, err = c.ReadMsgUnix(buf, nil)
+	case *net.UDPConn:
+		n, _, flags, _, err = c.ReadMsgUDP(buf, nil)
+	default:
+		n, err = c.Read(buf)
+	}
+	if err == nil && flags&syscall.MSG_TRUNC != 0 {
+		return n, fmt.Errorf("%w: datagram larger than %d bytes", ErrTruncated, len(buf))
+	}
+	return n, err
+}
+
+// replyComplete reports whether reply is a complete JSON-RPC response or
+// ends with a complete line of text.
+func replyComplete(reply []byte) bool {
+	if bytes.HasPrefix(bytes.TrimSpace(reply), []byte("{")) {
+		return json.Valid(reply)
+	}
+	return bytes.HasSuffix(reply, []byte("\n"))
+}
+
+// Transport implements StatisticsSource.
+func (o *OpenSIPS) Transport() string {
+	return datagramTransport
 }
 
 // Close tears down all resources created for this OpenSIPS instance.
 func (o *OpenSIPS) Close() error {
-	err := os.Remove(o.tmpdir)
-	return err
+	if o.tmpdir == "" {
+		return nil
+	}
+	if err := os.Remove(o.lock.Name()); err != nil {
+		return err
+	}
+	if err := o.lock.Close(); err != nil {
+		return err
+	}
+	return os.Remove(o.tmpdir)
 }
@@ -1,7 +1,15 @@
 package opensips_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,21 +29,21 @@ func TestGetStatistics(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	o, err := opensips.New(m.Socket())
+	o, err := opensips.New(m.Socket(), opensips.FormatAuto)
 	if err != nil {
 		t.Fatal(err)
 	}
 	var g errgroup.Group
 	g.Go(func() error {
-		statistics, err := o.GetStatistics("fake_statistic")
+		statistics, err := o.GetStatistics(context.Background(), "fake_statistic")
 		if err != nil {
 			return err
 		}
 		if len(statistics) != 1 {
 			return fmt.Errorf("expected 1 line from GetStatistics, got %d", len(statistics))
 		}
-		if statistics["fake_statistic"] != fakeStatisticObject {
-			return fmt.Errorf("expected %v, got %v", fakeStatistic, statistics["fake_statistic"])
+		if statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}] != fakeStatisticObject {
+			return fmt.Errorf("expected %v, got %v", fakeStatistic, statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}])
 		}
 		return nil
 	})
@@ -64,22 +72,22 @@ func TestConcurrentGetStatistics(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	o, err := opensips.New(m.Socket())
+	o, err := opensips.New(m.Socket(), opensips.FormatAuto)
 	if err != nil {
 		t.Fatal(err)
 	}
 	var g errgroup.Group
 	for i := 0; i < 10; i++ {
 		g.Go(func() error {
-			statistics, err := o.GetStatistics("fake_statistic")
+			statistics, err := o.GetStatistics(context.Background(), "fake_statistic")
 			if err != nil {
 				return err
 			}
 			if len(statistics) != 1 {
 				return fmt.Errorf("expected 1 line from GetStatistics, got %d", len(statistics))
 			}
-			if statistics["fake_statistic"] != fakeStatisticObject {
-				return fmt.Errorf("expected %v, got %v", fakeStatisticObject, statistics["fake_statistic"])
+			if statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}] != fakeStatisticObject {
+				return fmt.Errorf("expected %v, got %v", fakeStatisticObject, statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}])
 			}
 			return nil
 		})
@@ -97,3 +105,390 @@ func TestConcurrentGetStatistics(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGetStatisticsJSON(t *testing.T) {
+	const fakeStatistic = `{"jsonrpc":"2.0","result":{"core:fake_statistic":42},"id":1}`
+	var fakeStatisticObject = opensips.Statistic{
+		Name:   "fake_statistic",
+		Module: "core",
+		Value:  42,
+	}
+	m, err := mock.New([]byte(fakeStatistic), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := opensips.New(m.Socket(), opensips.FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g errgroup.Group
+	g.Go(func() error {
+		statistics, err := o.GetStatistics(context.Background(), "fake_statistic")
+		if err != nil {
+			return err
+		}
+		if statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}] != fakeStatisticObject {
+			return fmt.Errorf("expected %v, got %v", fakeStatisticObject, statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}])
+		}
+		return nil
+	})
+	// The first request in the text format is answered in JSON, after which
+	// the request is sent again as JSON-RPC.
+	if err := m.Run(2, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatisticsUDP(t *testing.T) {
+	const fakeStatistic = "core:fake_statistic = 42\n"
+	var fakeStatisticObject = opensips.Statistic{
+		Name:   "fake_statistic",
+		Module: "core",
+		Value:  42,
+	}
+	m, err := mock.NewUDP([]byte("200 OK\n"+fakeStatistic), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := opensips.New(m.Socket(), opensips.FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g errgroup.Group
+	g.Go(func() error {
+		statistics, err := o.GetStatistics(context.Background(), "fake_statistic")
+		if err != nil {
+			return err
+		}
+		if statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}] != fakeStatisticObject {
+			return fmt.Errorf("expected %v, got %v", fakeStatisticObject, statistics[opensips.StatisticKey{Module: "core", Name: "fake_statistic"}])
+		}
+		return nil
+	})
+	if err := m.Run(1, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// datagramReplySize is the size of the reply buffer of mi_datagram.
+const datagramReplySize = 65457
+
+func TestGetStatisticsMultipleDatagrams(t *testing.T) {
+	var response strings.Builder
+	response.WriteString("200 OK\n")
+	const processes = 5000
+	for i := 0; i < processes; i++ {
+		fmt.Fprintf(&response, "pkmem:%d-free_size:: 1024\n", i)
+	}
+	m, err := mock.New([]byte(response.String()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Split(datagramReplySize)
+	o, err := opensips.New(m.Socket(), opensips.FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g errgroup.Group
+	g.Go(func() error {
+		statistics, err := o.GetStatistics(context.Background(), "pkmem:")
+		if err != nil {
+			return err
+		}
+		if len(statistics) != processes {
+			return fmt.Errorf("expected %d statistics from GetStatistics, got %d", processes, len(statistics))
+		}
+		return nil
+	})
+	if err := m.Run(1, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatisticsTruncated(t *testing.T) {
+	// A full datagram without the rest of the reply.
+	response := "200 OK\n" + strings.Repeat("core:fake_statistic:: 42\n", datagramReplySize)
+	m, err := mock.New([]byte(response[:datagramReplySize]), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := opensips.New(m.Socket(), opensips.FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g errgroup.Group
+	g.Go(func() error {
+		_, err := o.GetStatistics(context.Background(), "core:")
+		if !errors.Is(err, opensips.ErrTruncated) {
+			return fmt.Errorf("expected ErrTruncated, got %v", err)
+		}
+		return nil
+	})
+	if err := m.Run(1, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	const response = "200 OK\nSET:: 1\n\tURI:: sip:10.0.0.1:5060 state=Active weight=1\n\tURI:: sip:10.0.0.2:5060 state=Inactive\n"
+	expected := []interface{}{
+		map[string]interface{}{
+			"name":  "SET",
+			"value": "1",
+			"children": []interface{}{
+				map[string]interface{}{
+					"name":       "URI",
+					"value":      "sip:10.0.0.1:5060",
+					"attributes": map[string]interface{}{"state": "Active", "weight": "1"},
+				},
+				map[string]interface{}{
+					"name":       "URI",
+					"value":      "sip:10.0.0.2:5060",
+					"attributes": map[string]interface{}{"state": "Inactive"},
+				},
+			},
+		},
+	}
+	m, err := mock.New([]byte(response), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := opensips.New(m.Socket(), opensips.FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g errgroup.Group
+	g.Go(func() error {
+		result, err := o.Call(context.Background(), "ds_list")
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(result, expected) {
+			return fmt.Errorf("expected %v, got %v", expected, result)
+		}
+		return nil
+	})
+	if err := m.Run(1, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseStatisticsError(t *testing.T) {
+	statistics, err := opensips.ParseStatistics([]string{
+		"core:rcv_requests = 42",
+		"shmem:total_size:: n/a",
+		"shmem:used_size:: 1024",
+		"garbage",
+	})
+	var parseErr *opensips.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a ParseError, got %v", err)
+	}
+	expected := map[string]int{"shmem": 1, "": 1}
+	if !reflect.DeepEqual(parseErr.Modules, expected) {
+		t.Errorf("expected parse errors %v, got %v", expected, parseErr.Modules)
+	}
+	if len(statistics) != 2 {
+		t.Errorf("expected 2 statistics from ParseStatistics, got %d", len(statistics))
+	}
+}
+
+func TestParseStatisticsSameName(t *testing.T) {
+	statistics, err := opensips.ParseStatistics([]string{
+		"shmem:free_size = 1024",
+		"pkmem:free_size = 512",
+		"tm:UAS_transactions = 3",
+		"tmx:UAS_transactions = 4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statistics) != 4 {
+		t.Fatalf("expected 4 statistics from ParseStatistics, got %d: %v", len(statistics), statistics)
+	}
+	if v := statistics.Value("pkmem", "free_size"); v != 512 {
+		t.Errorf("expected pkmem:free_size 512, got %v", v)
+	}
+	if v := statistics.Value("shmem", "free_size"); v != 1024 {
+		t.Errorf("expected shmem:free_size 1024, got %v", v)
+	}
+	expected := []string{"pkmem", "shmem", "tm", "tmx"}
+	if modules := statistics.Modules(); !reflect.DeepEqual(modules, expected) {
+		t.Errorf("expected modules %v, got %v", expected, modules)
+	}
+	if stats := statistics.Module("tmx"); len(stats) != 1 || stats[0].Value != 4 {
+		t.Errorf("expected tmx:UAS_transactions 4, got %v", stats)
+	}
+}
+
+func TestGetStatisticsContext(t *testing.T) {
+	m, err := mock.New([]byte("200 OK\ncore:fake_statistic = 42\n"), 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := opensips.New(m.Socket(), opensips.FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g errgroup.Group
+	g.Go(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := o.GetStatistics(ctx, "core:")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+		return nil
+	})
+	// The reply is sent after the client stopped waiting for it, so sending
+	// it fails.
+	m.Run(1, time.Now().Add(10*time.Second))
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoSource(t *testing.T) {
+	const response = "200 OK\nServer:: OpenSIPS (2.4.5 (x86_64/linux))\nBuild:: Mon Jan  1 00:00:00 2018\n"
+	m, err := mock.New([]byte(response), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := opensips.NewAutoSource(opensips.DefaultCandidates(m.Socket(), opensips.Config{}))
+	var g errgroup.Group
+	g.Go(func() error {
+		// The JSON-RPC candidate can't parse the text reply, after which the
+		// text candidate is tried.
+		if _, err := a.Call(context.Background(), "version"); err != nil {
+			return err
+		}
+		expected := opensips.Detected{Protocol: "mi_datagram", Format: opensips.FormatText, Version: "2.4.5"}
+		if d, ok := a.Detected(); !ok || d != expected {
+			return fmt.Errorf("expected %v to be detected, got %v", expected, d)
+		}
+		return nil
+	})
+	if err := m.Run(3, time.Now().Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	tests := []struct {
+		reply    interface{}
+		expected string
+	}{
+		{map[string]interface{}{"Server": "OpenSIPS (3.1.2 (x86_64/linux))"}, "3.1.2"},
+		{[]interface{}{map[string]interface{}{"name": "Server", "value": "OpenSIPS (1.11.10-notls (x86_64/linux))"}}, "1.11.10-notls"},
+		{map[string]interface{}{"Server": "unknown"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if v := opensips.ServerVersion(tt.reply); v != tt.expected {
+			t.Errorf("expected version %q for %v, got %q", tt.expected, tt.reply, v)
+		}
+	}
+}
+
+func TestRemoveStaleDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opensips-stale-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "opensips.sock")
+
+	o, err := opensips.New(socket, opensips.FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Left behind by an exporter that crashed: the lock file isn't locked.
+	crashed := path.Join(dir, "opensips_exporter123")
+	if err := os.Mkdir(crashed, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(crashed, "lock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Left behind by an older version, which didn't create a lock file.
+	old := path.Join(dir, "opensips_exporter456")
+	if err := os.Mkdir(old, 0700); err != nil {
+		t.Fatal(err)
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := opensips.RemoveStaleDirs(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if expected := []string{crashed, old}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected %v to be removed, got %v", expected, removed)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatalf("expected the directory in use to be kept, got %v", err)
+	}
+}
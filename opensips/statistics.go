@@ -0,0 +1,66 @@
+package opensips
+
+import (
+	"sort"
+)
+
+// StatisticKey identifies a statistic by its module and name.
+type StatisticKey struct {
+	Module, Name string
+}
+
+// Statistics holds statistics by module and name, so that statistics with
+// the same name in different modules (e.g. shmem:free_size and
+// pkmem:free_size, or tm:UAS_transactions and tmx:UAS_transactions) don't
+// overwrite each other.
+type Statistics map[StatisticKey]Statistic
+
+// Add adds stat, replacing the statistic with the same module and name.
+func (s Statistics) Add(stat Statistic) {
+	s[StatisticKey{stat.Module, stat.Name}] = stat
+}
+
+// Merge adds all statistics of other.
+func (s Statistics) Merge(other Statistics) {
+	for key, stat := range other {
+		s[key] = stat
+	}
+}
+
+// Get returns the statistic name of module, if there is one.
+func (s Statistics) Get(module, name string) (Statistic, bool) {
+	stat, ok := s[StatisticKey{module, name}]
+	return stat, ok
+}
+
+// Value returns the value of the statistic name of module, or 0 when there's
+// no such statistic.
+func (s Statistics) Value(module, name string) float64 {
+	return s[StatisticKey{module, name}].Value
+}
+
+// Module returns the statistics of module, sorted by name.
+func (s Statistics) Module(module string) []Statistic {
+	var stats []Statistic
+	for key, stat := range s {
+		if key.Module == module {
+			stats = append(stats, stat)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Modules returns the modules there are statistics of, sorted.
+func (s Statistics) Modules() []string {
+	seen := make(map[string]bool)
+	var modules []string
+	for key := range s {
+		if !seen[key.Module] {
+			seen[key.Module] = true
+			modules = append(modules, key.Module)
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
@@ -0,0 +1,147 @@
+package opensips
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// StatisticsSource is implemented by the clients for each of the Management
+// Interface transports.
+type StatisticsSource interface {
+	// GetStatistics calls the get_statistics management function and returns
+	// the statistics OpenSIPS sends back. The call is aborted when ctx is done.
+	GetStatistics(ctx context.Context, targets ...string) (Statistics, error)
+	// Close tears down all resources created for the client.
+	Close() error
+	// Transport returns the name of the transport used (e.g. "mi_datagram").
+	Transport() string
+}
+
+// Caller is implemented by the clients that can call any management function
+// of the Management Interface.
+type Caller interface {
+	// Call calls the management function method with the given parameters
+	// and returns the reply as a tree of maps, slices and scalars.
+	Call(ctx context.Context, method string, params ...interface{}) (interface{}, error)
+}
+
+// Transport describes how to connect to the Management Interface using a
+// specific transport.
+type Transport struct {
+	// Flag is the name of the command line flag holding the address of the
+	// Management Interface for this transport.
+	Flag string
+	// Default is the default value of Flag.
+	Default string
+	// Usage is the help text of Flag.
+	Usage string
+	// New creates a StatisticsSource for the Management Interface described by
+	// the Config.
+	New func(c Config) (StatisticsSource, error)
+}
+
+// Config holds the settings for connecting to the Management Interface.
+type Config struct {
+	// Address is where the Management Interface can be reached, e.g. the path
+	// of a socket or a URL.
+	Address string
+	// Format is the framing used by the mi_datagram and mi_fifo transports.
+	Format Format
+	// Timeout is how long to wait for a reply. When zero, mi_datagram and
+	// mi_fifo wait DefaultTimeout and the HTTP based transports don't time
+	// out.
+	Timeout time.Duration
+	// ObserveRoundtrip is called, when set, with the name of the management
+	// function and the duration of every call to the Management Interface.
+	ObserveRoundtrip func(command string, d time.Duration)
+	// HTTP holds the authentication, TLS and header settings of the HTTP
+	// based transports.
+	HTTP HTTPConfig
+	// FIFO holds the reply FIFO settings of the mi_fifo transport.
+	FIFO FIFOConfig
+}
+
+// DefaultTimeout is how long the mi_datagram and mi_fifo transports wait for
+// a reply by default.
+const DefaultTimeout = time.Second
+
+// ReplyError is returned when OpenSIPS answered a call with an error, e.g.
+// because the management function doesn't exist.
+type ReplyError struct {
+	Code    int
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+// Format is the framing of the requests to and replies from the Management
+// Interface.
+type Format int32
+
+const (
+	// FormatAuto detects the framing on the first successful request.
+	FormatAuto Format = iota
+	// FormatText is the line based framing of OpenSIPS 1.x and 2.x.
+	FormatText
+	// FormatJSON is the JSON-RPC framing of OpenSIPS >= 3.0.
+	FormatJSON
+)
+
+var formatNames = map[Format]string{
+	FormatAuto: "auto",
+	FormatText: "text",
+	FormatJSON: "json",
+}
+
+// String returns the name of the Format as accepted by ParseFormat.
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// ParseFormat returns the Format with the given name ("auto", "text" or
+// "json").
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	return FormatAuto, fmt.Errorf("unknown format %q", name)
+}
+
+// Transports is a map of the available transports, keyed by protocol name.
+var Transports = make(map[string]Transport)
+
+// TransportNames returns the sorted names of all available transports.
+func TransportNames() []string {
+	var names []string
+	for name := range Transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Transports[datagramTransport] = Transport{
+		Flag:    "socket",
+		Default: "/var/run/ser-fg/ser.sock",
+		Usage:   "Path to the socket file for OpenSIPS, or udp://host:port when mi_datagram listens on UDP.",
+		New: func(c Config) (StatisticsSource, error) {
+			o, err := New(c.Address, c.Format)
+			if err != nil {
+				return nil, err
+			}
+			if c.Timeout > 0 {
+				o.timeout = c.Timeout
+			}
+			o.observe = c.ObserveRoundtrip
+			return o, nil
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package opensips
+
+import (
+	"strings"
+)
+
+// The keys of the maps a node of a reply in the text format is returned as by
+// Call. Every node has a name and a value, attributes and children are only
+// present when the node has any.
+const (
+	TextNodeName       = "name"
+	TextNodeValue      = "value"
+	TextNodeAttributes = "attributes"
+	TextNodeChildren   = "children"
+)
+
+type textNode struct {
+	name, value string
+	attributes  map[string]interface{}
+	children    []*textNode
+}
+
+// parseTextTree parses a reply in the line based format of OpenSIPS < 3.0,
+// in which every line is a node of the reply, indented with a tab per level:
+//
+//	name:: value attribute=value
+func parseTextTree(resp []byte) ([]interface{}, error) {
+	lines := strings.Split(string(resp), "\n")
+	if lines[0]+"\n" != firstLineOK {
+		return nil, textReplyError(lines[0])
+	}
+	root := &textNode{}
+	stack := []*textNode{root}
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		depth := len(line) - len(strings.TrimLeft(line, "\t"))
+		n := parseTextNode(line[depth:])
+		if depth > len(stack)-1 {
+			// Not indented as expected, add it to the deepest node.
+			depth = len(stack) - 1
+		}
+		parent := stack[depth]
+		parent.children = append(parent.children, n)
+		stack = append(stack[:depth+1], n)
+	}
+	return root.childTrees(), nil
+}
+
+func parseTextNode(line string) *textNode {
+	n := &textNode{}
+	rest := line
+	if i := strings.Index(line, "::"); i >= 0 {
+		n.name = line[:i]
+		rest = line[i+2:]
+	}
+	var value []string
+	var attribute string
+	for _, field := range strings.Fields(rest) {
+		if i := strings.Index(field, "="); i > 0 {
+			attribute = field[:i]
+			if n.attributes == nil {
+				n.attributes = make(map[string]interface{})
+			}
+			n.attributes[attribute] = field[i+1:]
+			continue
+		}
+		if attribute != "" {
+			// Attribute values may contain spaces.
+			n.attributes[attribute] = n.attributes[attribute].(string) + " " + field
+			continue
+		}
+		value = append(value, field)
+	}
+	n.value = strings.Join(value, " ")
+	return n
+}
+
+// tree returns the node as a map.
+func (n *textNode) tree() map[string]interface{} {
+	t := map[string]interface{}{
+		TextNodeName:  n.name,
+		TextNodeValue: n.value,
+	}
+	if n.attributes != nil {
+		t[TextNodeAttributes] = n.attributes
+	}
+	if len(n.children) > 0 {
+		t[TextNodeChildren] = n.childTrees()
+	}
+	return t
+}
+
+func (n *textNode) childTrees() []interface{} {
+	children := []interface{}{}
+	for _, c := range n.children {
+		children = append(children, c.tree())
+	}
+	return children
+}
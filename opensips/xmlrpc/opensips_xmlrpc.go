@@ -0,0 +1,190 @@
+package xmlrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+)
+
+const transport = "mi_xmlrpc"
+
+// XMLRPC holds all the information necessary for handling connections to
+// the OpenSIPS Management Interface through the mi_xmlrpc module (targeting
+// versions 1.x and 2.x).
+type XMLRPC struct {
+	url    string
+	client *http.Client
+	// observe is called with the duration of every call, when set.
+	observe func(command string, d time.Duration)
+}
+
+// New creates a new XMLRPC instance. Pass it the running OpenSIPS'
+// XML-RPC endpoint to connect to.
+func New(url string) *XMLRPC {
+	return &XMLRPC{
+		url:    url,
+		client: &http.Client{},
+	}
+}
+
+func init() {
+	opensips.Transports[transport] = opensips.Transport{
+		Flag:    "xmlrpc_address",
+		Default: "http://127.0.0.1:8080/RPC2",
+		Usage:   "Address to query the Management Interface through XML-RPC with (e.g. http://127.0.0.1:8080/RPC2)",
+		New: func(c opensips.Config) (opensips.StatisticsSource, error) {
+			o := New(c.Address)
+			client, err := c.HTTP.Client(c.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			o.client = client
+			o.observe = c.ObserveRoundtrip
+			return o, nil
+		},
+	}
+}
+
+type methodCall struct {
+	XMLName    xml.Name `xml:"methodCall"`
+	MethodName string   `xml:"methodName"`
+	Params     []string `xml:"params>param>value>string"`
+}
+
+type methodResponse struct {
+	Params []value `xml:"params>param>value"`
+	Fault  *value  `xml:"fault>value"`
+}
+
+// value is an XML-RPC value. A value without a type is a string.
+type value struct {
+	String *string `xml:"string"`
+	Int    *string `xml:"int"`
+	I4     *string `xml:"i4"`
+	Double *string `xml:"double"`
+	Struct *struct {
+		Members []member `xml:"member"`
+	} `xml:"struct"`
+	Array *struct {
+		Values []value `xml:"data>value"`
+	} `xml:"array"`
+	Text string `xml:",chardata"`
+}
+
+type member struct {
+	Name  string `xml:"name"`
+	Value value  `xml:"value"`
+}
+
+// scalar returns the value of a string or number as a string.
+func (v value) scalar() string {
+	for _, s := range []*string{v.String, v.Int, v.I4, v.Double} {
+		if s != nil {
+			return strings.TrimSpace(*s)
+		}
+	}
+	return strings.TrimSpace(v.Text)
+}
+
+// GetStatistics calls the XML-RPC endpoint and returns the
+// statistics OpenSIPS sends back. The targets can be "all", "group:" or
+// "name" (e.g. "shmem:" or "rcv_requests").
+func (o *XMLRPC) GetStatistics(ctx context.Context, targets ...string) (opensips.Statistics, error) {
+	if o.observe != nil {
+		defer func(start time.Time) { o.observe("get_statistics", time.Since(start)) }(time.Now())
+	}
+	// Every target is passed as a separate string parameter.
+	body, err := xml.Marshal(methodCall{
+		MethodName: "get_statistics",
+		Params:     targets,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(append([]byte(xml.Header), body...)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	resp, err := o.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error while getting statistics from XML-RPC endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error while getting statistics from XML-RPC endpoint: %s", resp.Status)
+	}
+
+	var r methodResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error while decoding XML-RPC response: %w", err)
+	}
+	if r.Fault != nil {
+		return nil, fmt.Errorf("error while getting statistics from XML-RPC endpoint: %w", faultError(*r.Fault))
+	}
+	if len(r.Params) != 1 {
+		return nil, fmt.Errorf("expected 1 parameter in XML-RPC response, got %d", len(r.Params))
+	}
+
+	return opensips.ParseStatistics(statisticLines(r.Params[0]))
+}
+
+// statisticLines turns the reply of get_statistics into lines in the format
+// of the other Management Interface transports, e.g.
+// "shmem:total_size = 2147483648". Depending on the version and settings of
+// mi_xmlrpc the reply is a struct of statistics, an array of lines or a
+// single string of lines.
+func statisticLines(v value) []string {
+	var lines []string
+	switch {
+	case v.Struct != nil:
+		for _, m := range v.Struct.Members {
+			lines = append(lines, fmt.Sprintf("%s = %s", strings.TrimSpace(m.Name), m.Value.scalar()))
+		}
+	case v.Array != nil:
+		for _, item := range v.Array.Values {
+			lines = append(lines, statisticLines(item)...)
+		}
+	default:
+		for _, line := range strings.Split(v.scalar(), "\n") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
+
+// faultError returns the fault OpenSIPS sent back as an
+// *opensips.ReplyError.
+func faultError(v value) error {
+	if v.Struct == nil {
+		return &opensips.ReplyError{Message: v.scalar()}
+	}
+	var e opensips.ReplyError
+	for _, m := range v.Struct.Members {
+		switch m.Name {
+		case "faultCode":
+			e.Code, _ = strconv.Atoi(m.Value.scalar())
+		case "faultString":
+			e.Message = m.Value.scalar()
+		}
+	}
+	return &e
+}
+
+// Transport implements opensips.StatisticsSource.
+func (o *XMLRPC) Transport() string {
+	return transport
+}
+
+// Close implements opensips.StatisticsSource. The XML-RPC client holds no
+// resources, so this is a no-op.
+func (o *XMLRPC) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package xmlrpc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/opensips/xmlrpc"
+)
+
+// server returns a stand-in for mi_xmlrpc which answers get_statistics with
+// the given response.
+func server(t *testing.T, response string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		var call struct {
+			MethodName string   `xml:"methodName"`
+			Params     []string `xml:"params>param>value>string"`
+		}
+		if err := xml.Unmarshal(body, &call); err != nil {
+			t.Errorf("invalid request %q: %v", body, err)
+		}
+		if call.MethodName != "get_statistics" {
+			t.Errorf("expected get_statistics to be called, got %q", call.MethodName)
+		}
+		if strings.Join(call.Params, ",") != "core:,shmem:" {
+			t.Errorf("expected parameters core: and shmem:, got %v", call.Params)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<?xml version="1.0"?><methodResponse>` + response + `</methodResponse>`))
+	}))
+}
+
+func TestGetStatistics(t *testing.T) {
+	expected := []opensips.Statistic{
+		{Module: "core", Name: "rcv_requests", Value: 42},
+		{Module: "shmem", Name: "total_size", Value: 2147483648},
+	}
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"struct", `<params><param><value><struct>
+			<member><name>core:rcv_requests</name><value><int>42</int></value></member>
+			<member><name>shmem:total_size</name><value><string>2147483648</string></value></member>
+			</struct></value></param></params>`},
+		{"array", `<params><param><value><array><data>
+			<value><string>core:rcv_requests = 42</string></value>
+			<value><string>shmem:total_size = 2147483648</string></value>
+			</data></array></value></param></params>`},
+		{"string", `<params><param><value><string>core:rcv_requests:: 42
+shmem:total_size:: 2147483648
+</string></value></param></params>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server(t, tt.response)
+			defer s.Close()
+
+			statistics, err := xmlrpc.New(s.URL).GetStatistics(context.Background(), "core:", "shmem:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(statistics) != len(expected) {
+				t.Fatalf("expected %d statistics, got %d: %v", len(expected), len(statistics), statistics)
+			}
+			for _, stat := range expected {
+				if got, _ := statistics.Get(stat.Module, stat.Name); got != stat {
+					t.Errorf("expected %v, got %v", stat, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGetStatisticsFault(t *testing.T) {
+	s := server(t, `<fault><value><struct>
+		<member><name>faultCode</name><value><int>500</int></value></member>
+		<member><name>faultString</name><value><string>command not available</string></value></member>
+		</struct></value></fault>`)
+	defer s.Close()
+
+	_, err := xmlrpc.New(s.URL).GetStatistics(context.Background(), "core:", "shmem:")
+	if err == nil || !strings.Contains(err.Error(), "command not available") {
+		t.Fatalf("expected fault to be returned as error, got %v", err)
+	}
+}
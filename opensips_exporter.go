@@ -1,140 +1,346 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
-
-	"fmt"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/VoIPGRID/opensips_exporter/opensips"
-	"github.com/VoIPGRID/opensips_exporter/opensips/jsonrpc"
+	// Register the mi_http and mi_xmlrpc transports.
+	_ "github.com/VoIPGRID/opensips_exporter/opensips/jsonrpc"
+	_ "github.com/VoIPGRID/opensips_exporter/opensips/xmlrpc"
 	"github.com/VoIPGRID/opensips_exporter/processors"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var o *opensips.OpenSIPS
-var j *jsonrpc.JSONRPC
-var collectAll = []string{"core:", "shmem:", "net:", "uri:", "tm:", "sl:", "usrloc:", "dialog:", "registrar:", "pkmem:", "load:", "tmx:"}
+var collectAll = []string{"core:", "shmem:", "net:", "uri:", "tm:", "sl:", "usrloc:", "dialog:", "registrar:", "pkmem:", "load:", "tmx:", "build_info"}
 
 const envPrefix = "OPENSIPS_EXPORTER"
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	collect := r.URL.Query()["collect[]"]
-	collectors := make(map[prometheus.Collector]bool)
+// statisticsPoller polls the statistics in the background when -poll_interval
+// is set and no config file is used.
+var statisticsPoller *poller
 
-	if len(collect) == 0 {
-		// Collect everything if nothing is specified
-		collect = collectAll
-	}
-	var scrapeProcessor prometheus.Collector
+// scrapeSource is the client for the OpenSIPS when no config file is used.
+// It's created at startup and shared by all scrapes.
+var scrapeSource *instrumentedSource
 
-	var statistics map[string]opensips.Statistic
-	var err error
-	switch *protocol {
-	case "mi_datagram":
-		o, err = opensips.New(*socketPath)
-		if err != nil {
-			log.Fatalf("Could not create datagram socket: %v", err)
+func handler(w http.ResponseWriter, r *http.Request) {
+	if statisticsPoller != nil {
+		if err := statisticsPoller.checkCollect(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		statistics, err = o.GetStatistics(collect...)
-	case "mi_http":
-		j = jsonrpc.New(*httpEndpoint)
-		statistics, err = j.GetStatistics(collect...)
+		serveMetrics(w, r, prometheus.Gatherers{prometheus.DefaultGatherer, statisticsPoller.registry()})
+		return
 	}
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	registry := scrape(ctx, scrapeSource, collectGroups(r, scrapeSource, nil))
+	serveMetrics(w, r, prometheus.Gatherers{prometheus.DefaultGatherer, registry})
+}
 
-	if err != nil {
-		scrapeProcessor = processors.NewScrapeProcessor(0)
-		log.Printf("Error encountered while reading statistics from opensips socket: %v", err)
-	} else {
-		scrapeProcessor = processors.NewScrapeProcessor(1)
+// strflag is like flag.String, with value overridden by an environment
+// variable (when present). e.g. with socket path, the env var used as default
+// is OPENSIPS_EXPORTER_SOCKET_PATH, if present in env.
+func strflag(name string, value string, usage string) *string {
+	if v, ok := os.LookupEnv(envPrefix + strings.ToUpper(name)); ok {
+		return flag.String(name, v, usage)
 	}
-	collectors[scrapeProcessor] = true
+	return flag.String(name, value, usage)
+}
 
-	var selectedProcessors = map[string]bool{}
-	for _, processor := range collect {
-		if p, ok := processors.OpensipsProcessors[processor]; ok {
-			processorFunc := fmt.Sprintf("%p", p)
-			if _, ok := selectedProcessors[processorFunc]; !ok {
-				selectedProcessors[processorFunc] = true
-				collectors[p(statistics)] = true
-			}
+// boolflag is like flag.Bool, with value overridden by an environment
+// variable (when present), see strflag.
+func boolflag(name string, value bool, usage string) *bool {
+	if v, ok := os.LookupEnv(envPrefix + strings.ToUpper(name)); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			value = b
 		}
 	}
+	return flag.Bool(name, value, usage)
+}
 
-	registry := prometheus.NewRegistry()
-	for collector := range collectors {
-		err := registry.Register(collector)
-		if err != nil {
-			log.Printf("Problems registering the %T processor (could be due to no metrics found for this processor). Error: %v\n", collector, err)
+// intflag is like flag.Int, with value overridden by an environment variable
+// (when present), see strflag.
+func intflag(name string, value int, usage string) *int {
+	if v, ok := os.LookupEnv(envPrefix + strings.ToUpper(name)); ok {
+		if i, err := strconv.Atoi(v); err == nil {
+			value = i
 		}
 	}
+	return flag.Int(name, value, usage)
+}
 
-	gatherers := prometheus.Gatherers{
-		prometheus.DefaultGatherer,
-		registry,
+// durationflag is like flag.Duration, with value overridden by an environment
+// variable (when present), see strflag.
+func durationflag(name string, value time.Duration, usage string) *time.Duration {
+	if v, ok := os.LookupEnv(envPrefix + strings.ToUpper(name)); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			value = d
+		}
 	}
+	return flag.Duration(name, value, usage)
+}
+
+// headerFlag is a flag.Value holding HTTP headers, set as "Name: value".
+type headerFlag map[string]string
 
-	// Delegate http serving to Prometheus client library, which will call collector.Collect.
-	h := promhttp.HandlerFor(gatherers,
-		promhttp.HandlerOpts{
-			ErrorHandling: promhttp.ContinueOnError,
-		})
-	h.ServeHTTP(w, r)
+func (h *headerFlag) String() string {
+	var headers []string
+	for name, value := range *h {
+		headers = append(headers, name+": "+value)
+	}
+	sort.Strings(headers)
+	return strings.Join(headers, ", ")
 }
 
-// strflag is like flag.String, with value overridden by an environment
-// variable (when present). e.g. with socket path, the env var used as default
-// is OPENSIPS_EXPORTER_SOCKET_PATH, if present in env.
-func strflag(name string, value string, usage string) *string {
-	if v, ok := os.LookupEnv(envPrefix + strings.ToUpper(name)); ok {
-		return flag.String(name, v, usage)
+func (h *headerFlag) Set(v string) error {
+	i := strings.Index(v, ":")
+	if i <= 0 {
+		return fmt.Errorf("expected 'Name: value', got %q", v)
 	}
-	return flag.String(name, value, usage)
+	(*h)[strings.TrimSpace(v[:i])] = strings.TrimSpace(v[i+1:])
+	return nil
 }
 
 var (
-	socketPath   *string
-	metricsPath  *string
-	addr         *string
-	protocol     *string
-	httpEndpoint *string
+	webConfigFile *string
+	configFile    *string
+	timeoutOffset *time.Duration
+	pollInterval  *time.Duration
+	fallback      *bool
+	metricsPath   *string
+	addr          *string
+	protocol      *string
+	format        *string
+	miFormat      opensips.Format
+	// addresses holds the Management Interface address flag of each transport.
+	addresses = make(map[string]*string)
+	// timeouts holds the timeout flag of each transport.
+	timeouts = make(map[string]*time.Duration)
+
+	// httpConfig holds the settings of the -http_* flags.
+	httpConfig opensips.HTTPConfig
+	// fifoConfig holds the settings of the -fifo_reply_* flags.
+	fifoConfig opensips.FIFOConfig
+	// commandConfig holds the settings of the command processors, e.g. of
+	// the -drouting_partitions flag.
+	commandConfig processors.CommandConfig
+
+	retries         *int
+	retryBackoff    *time.Duration
+	breakerFailures *int
+	breakerCooldown *time.Duration
 )
 
 func main() {
 	addr = strflag("addr", ":9434", "Address on which the OpenSIPS exporter listens. (e.g. 127.0.0.1:9434)")
 	metricsPath = strflag("path", "/metrics", "The path where metrics will be served.")
-	socketPath = strflag("socket", "/var/run/ser-fg/ser.sock", "Path to the socket file for OpenSIPS.)")
-	httpEndpoint = strflag("http_address", "http://127.0.0.1:8888/mi/", "Address to query the Management Interface through HTTP with (e.g. http://127.0.0.1:8888/mi/)")
-	protocol = strflag("protocol", "", "Which protocol to use to get data from the Management Interface (mi_datagram & mi_http currently supported)")
+	for name, t := range opensips.Transports {
+		addresses[name] = strflag(t.Flag, t.Default, t.Usage)
+		timeouts[name] = durationflag(name+"_timeout", 0, "Timeout of the calls to the Management Interface through "+name+". When 0, mi_datagram and mi_fifo wait "+opensips.DefaultTimeout.String()+" and the HTTP based transports only stop at the scrape timeout.")
+	}
+	protocols := strings.Join(append(opensips.TransportNames(), opensips.AutoTransport), ", ")
+	protocol = strflag("protocol", "", "Which protocol to use to get data from the Management Interface ("+protocols+" currently supported). With auto, mi_http at -http_address, then mi_datagram at -socket in the JSON-RPC and then the text format are tried.")
+	format = strflag("format", "auto", "Format of the requests to the Management Interface for the mi_datagram and mi_fifo protocols: text (OpenSIPS < 3.0), json (OpenSIPS >= 3.0) or auto to detect it")
+	webConfigFile = strflag("web_config_file", "", "Path to a YAML file with the TLS and basic authentication settings of the listener, in the format of the Prometheus web config file.")
+	configFile = strflag("config", "", "Path to a YAML file with the OpenSIPS instances to export the metrics of. When set, the -protocol flag only applies to /probe.")
+	pollInterval = durationflag("poll_interval", 0, "Poll the statistics in the background at this interval and serve the last ones polled on scrapes, instead of reading them on every scrape. Disabled when 0.")
+	timeoutOffset = durationflag("timeout_offset", 500*time.Millisecond, "Offset to subtract from the scrape timeout Prometheus sends, to leave time to serve the metrics.")
+	retries = intflag("retries", 1, "Number of times a get_statistics call that failed because OpenSIPS couldn't be reached or didn't reply in time is retried.")
+	retryBackoff = durationflag("retry_backoff", 100*time.Millisecond, "Time to wait before retrying a failed get_statistics call, doubled for every next retry.")
+	breakerFailures = intflag("breaker_failures", 0, "Number of consecutive failed scrapes after which the Management Interface isn't called for -breaker_cooldown. A scrape fails when OpenSIPS couldn't be reached for all of its groups. Disabled when 0.")
+	breakerCooldown = durationflag("breaker_cooldown", 30*time.Second, "Time the Management Interface isn't called after -breaker_failures consecutive failed scrapes.")
+	httpUsername := strflag("http_username", "", "Username for basic authentication with mi_http and mi_xmlrpc.")
+	httpPassword := strflag("http_password", "", "Password for basic authentication with mi_http and mi_xmlrpc. Prefer -http_password_file, to keep it out of the process list.")
+	httpPasswordFile := strflag("http_password_file", "", "File holding the password for basic authentication with mi_http and mi_xmlrpc.")
+	httpBearerToken := strflag("http_bearer_token", "", "Bearer token for mi_http and mi_xmlrpc. Prefer -http_bearer_token_file, to keep it out of the process list.")
+	httpBearerTokenFile := strflag("http_bearer_token_file", "", "File holding the bearer token for mi_http and mi_xmlrpc.")
+	httpCAFile := strflag("http_ca_file", "", "PEM file with the CAs to verify the certificate of mi_http and mi_xmlrpc with, instead of the ones of the system.")
+	httpCertFile := strflag("http_cert_file", "", "Client certificate for mi_http and mi_xmlrpc.")
+	httpKeyFile := strflag("http_key_file", "", "Key of the -http_cert_file client certificate.")
+	httpInsecureSkipVerify := boolflag("http_insecure_skip_verify", false, "Don't verify the certificate of mi_http and mi_xmlrpc.")
+	httpHeaders := make(headerFlag)
+	flag.Var(&httpHeaders, "http_header", "Header to add to the requests to mi_http and mi_xmlrpc, as 'Name: value'. Can be repeated.")
+	fifoReplyDir := strflag("fifo_reply_dir", opensips.FIFOReplyDir, "Directory reply FIFOs of mi_fifo are created in, which has to be the reply_dir parameter of mi_fifo. Created with mode 0700 when missing.")
+	fifoReplyMode := strflag("fifo_reply_mode", "0600", "Permissions of the reply FIFOs of mi_fifo. Widen them (e.g. to 0620) only when OpenSIPS runs as another user.")
+	fallback = boolflag("fallback", false, "Export the statistics none of the processors export as opensips_<module>_<name>, and collect all statistics by default.")
+	droutingPartitions := strflag("drouting_partitions", "", "Comma separated partitions of the drouting module to read the gateways and carriers of with collect[]=drouting. Leave empty when the drouting module doesn't use partitions.")
+	probePathsFlag := strflag("probe_paths", "", "Comma separated paths of mi_datagram sockets and mi_fifo FIFOs /probe may use as target. Without them, /probe only accepts http://, https:// and udp:// targets.")
 	flag.Parse()
 
-	switch *protocol {
-	case "mi_datagram":
-		if *socketPath == "" {
-			log.Fatalf("The -protocol flag is set to mi_datagram but the -socket param is not set. Exiting.")
+	for _, partition := range strings.Split(*droutingPartitions, ",") {
+		if partition = strings.TrimSpace(partition); partition != "" {
+			commandConfig.DroutingPartitions = append(commandConfig.DroutingPartitions, partition)
 		}
-	case "mi_http":
-		if *httpEndpoint == "" {
-			log.Fatalf("The -protocol is set to mi_http but the -http_address flag is not set. Exiting.")
+	}
+
+	for _, path := range strings.Split(*probePathsFlag, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			probePaths[filepath.Clean(path)] = true
 		}
-	default:
-		log.Fatalf("Please set the -protocol flag to define which protocol the exporter should use to query for metrics. (mi_datagram or mi_http)")
 	}
 
-	http.HandleFunc(*metricsPath, handler)
+	httpConfig = opensips.HTTPConfig{
+		Username:           *httpUsername,
+		Password:           *httpPassword,
+		PasswordFile:       *httpPasswordFile,
+		BearerToken:        *httpBearerToken,
+		BearerTokenFile:    *httpBearerTokenFile,
+		CAFile:             *httpCAFile,
+		CertFile:           *httpCertFile,
+		KeyFile:            *httpKeyFile,
+		InsecureSkipVerify: *httpInsecureSkipVerify,
+		Headers:            httpHeaders,
+	}
+	if err := httpConfig.Validate(); err != nil {
+		log.Fatalf("Invalid -http_* flags: %v. Exiting.", err)
+	}
+	fifoConfig = opensips.FIFOConfig{
+		ReplyDir:  *fifoReplyDir,
+		ReplyMode: *fifoReplyMode,
+	}
+	if err := fifoConfig.Validate(); err != nil {
+		log.Fatalf("Invalid -fifo_reply_mode flag: %v. Exiting.", err)
+	}
+	var err error
+	miFormat, err = opensips.ParseFormat(*format)
+	if err != nil {
+		log.Fatalf("Invalid -format flag: %v. Exiting.", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		log.Printf("Received %v, shutting down", <-signals)
+		cancel()
+		// Don't wait for the shutdown when asked again.
+		log.Printf("Received %v, exiting", <-signals)
+		os.Exit(1)
+	}()
+	var pollers sync.WaitGroup
+	startPoller := func(p *poller) {
+		pollers.Add(1)
+		go func() {
+			defer pollers.Done()
+			p.run(ctx)
+		}()
+	}
+
+	if *configFile != "" {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Invalid -config file: %v. Exiting.", err)
+		}
+		for _, ic := range c.Instances {
+			if ic.Protocol == "mi_datagram" || ic.Protocol == opensips.AutoTransport {
+				removeStaleDirs(ic.Endpoint)
+			}
+		}
+		instances, err = newInstances(c)
+		if err != nil {
+			log.Fatalf("Could not create clients for the -config file: %v. Exiting.", err)
+		}
+		for _, i := range instances {
+			if i.poller != nil {
+				startPoller(i.poller)
+			}
+		}
+		http.HandleFunc(*metricsPath, instancesHandler)
+	} else {
+		config := opensips.Config{
+			Format: miFormat,
+			HTTP:   httpConfig,
+			FIFO:   fifoConfig,
+		}
+		if *protocol == opensips.AutoTransport {
+			// The candidates are taken from the address flags of the
+			// transports.
+			if *addresses["mi_http"] == "" && *addresses["mi_datagram"] == "" {
+				log.Fatalf("The -protocol flag is set to %s but neither the -http_address nor the -socket flag is set. Exiting.", *protocol)
+			}
+		} else {
+			t, ok := opensips.Transports[*protocol]
+			if !ok {
+				log.Fatalf("Please set the -protocol flag to define which protocol the exporter should use to query for metrics. (%s)", protocols)
+			}
+			if *addresses[*protocol] == "" {
+				log.Fatalf("The -protocol flag is set to %s but the -%s flag is not set. Exiting.", *protocol, t.Flag)
+			}
+			config.Address = *addresses[*protocol]
+			config.Timeout = transportTimeout(*protocol)
+		}
+		if *protocol == "mi_datagram" || *protocol == opensips.AutoTransport {
+			removeStaleDirs(*addresses["mi_datagram"])
+		}
+		scrapeSource, err = newInstrumentedSource(*protocol, config, processors.NewScrapeStats(), newBreaker(*breakerFailures, *breakerCooldown), commandConfig)
+		if err != nil {
+			log.Fatalf("Could not create %s client: %v", *protocol, err)
+		}
+		if *pollInterval > 0 {
+			statisticsPoller = newPoller(scrapeSource, defaultGroups(scrapeSource, nil), *pollInterval)
+			startPoller(statisticsPoller)
+		}
+		http.HandleFunc(*metricsPath, handler)
+	}
+	http.HandleFunc("/probe", probeHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>OpenSIPS Exporter</title></head>
 			<body>
 			<h1>OpenSIPS Exporter</h1>
 			<p><a href="` + *metricsPath + `">Metrics</a></p>
+			<p>Probe other OpenSIPS instances with /probe?target=&lt;address&gt;&amp;protocol=&lt;protocol&gt;</p>
 			</body>
 			</html>`))
 	})
 	log.Printf("Started OpenSIPS exporter, listening on %v", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	exitCode := 0
+	if err := listen(ctx, *addr, *webConfigFile, http.DefaultServeMux); err != nil {
+		log.Printf("Error serving the metrics: %v", err)
+		exitCode = 1
+	}
+
+	// The listener is shut down, so no scrape uses the clients anymore. The
+	// pollers still run when it failed to start.
+	cancel()
+	pollers.Wait()
+	if scrapeSource != nil {
+		scrapeSource.Close()
+	}
+	for _, i := range instances {
+		i.source.Close()
+	}
+	probeClients.close()
+	log.Printf("Stopped OpenSIPS exporter")
+	os.Exit(exitCode)
+}
+
+// removeStaleDirs removes the reply socket directories left behind next to
+// the mi_datagram socket by exporters that didn't exit cleanly.
+func removeStaleDirs(socket string) {
+	if socket == "" || strings.HasPrefix(socket, "http://") || strings.HasPrefix(socket, "https://") {
+		return
+	}
+	removed, err := opensips.RemoveStaleDirs(socket)
+	for _, dir := range removed {
+		log.Printf("Removed stale directory %s", dir)
+	}
+	if err != nil {
+		log.Printf("Error removing stale directories next to %s: %v", socket, err)
+	}
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/processors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poller polls the statistics of an OpenSIPS in the background, so that
+// scrapes are served from the last snapshot instead of hitting the Management
+// Interface every time.
+type poller struct {
+	source   *instrumentedSource
+	collect  []string
+	interval time.Duration
+
+	mu sync.RWMutex
+	// last is the snapshot served. A poll that couldn't read any statistics
+	// doesn't replace a snapshot that could.
+	last *snapshot
+	// lastFailed is set when the last poll couldn't read any statistics.
+	lastFailed bool
+}
+
+// newPoller creates a poller for the collect groups of source. Until the
+// first poll finishes, it serves an empty snapshot.
+func newPoller(source *instrumentedSource, collect []string, interval time.Duration) *poller {
+	return &poller{
+		source:   source,
+		collect:  collect,
+		interval: interval,
+		last:     &snapshot{time: time.Now(), stats: source.stats},
+	}
+}
+
+// run polls the statistics every interval, until ctx is done. A poll that
+// takes longer than the interval is aborted.
+func (p *poller) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, p.interval)
+		p.poll(pollCtx)
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll polls the statistics once. When it fails, the statistics of the last
+// successful poll are kept.
+func (p *poller) poll(ctx context.Context) {
+	s := fetchSnapshot(ctx, p.source, p.collect)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s.success() || !p.last.success() {
+		p.last = s
+	}
+	p.lastFailed = !s.success()
+}
+
+// registry returns a registry with the processors for the last snapshot.
+func (p *poller) registry() *prometheus.Registry {
+	p.mu.RLock()
+	s, lastFailed := p.last, p.lastFailed
+	p.mu.RUnlock()
+
+	var success float64
+	if s.success() && !lastFailed {
+		success = 1
+	}
+	return newRegistry(s, processors.NewPollProcessor(time.Since(s.time).Seconds(), success))
+}
+
+// checkCollect returns an error when r has collect[] parameters for other
+// groups than the ones polled, which can't be served from the snapshots.
+func (p *poller) checkCollect(r *http.Request) error {
+	collect := r.URL.Query()["collect[]"]
+	if len(collect) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("collect[] has to be left out or list the groups polled (%s)", strings.Join(p.collect, ", "))
+	polled := make(map[string]bool)
+	for _, group := range p.collect {
+		polled[group] = true
+	}
+	requested := make(map[string]bool)
+	for _, group := range collect {
+		if !polled[group] {
+			return err
+		}
+		requested[group] = true
+	}
+	if len(requested) != len(polled) {
+		return err
+	}
+	return nil
+}
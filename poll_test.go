@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pollSuccess returns the value of opensips_poll_last_success served by p.
+func pollSuccess(t *testing.T, p *poller) float64 {
+	t.Helper()
+	mfs, err := p.registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "opensips_poll_last_success" {
+			return mf.Metric[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatal("opensips_poll_last_success not found")
+	return 0
+}
+
+func TestPollKeepsLastSuccess(t *testing.T) {
+	fake := &fakeSource{}
+	p := newPoller(newFakeSource(fake, 0, 0), []string{"core:"}, time.Minute)
+
+	p.poll(context.Background())
+	if _, ok := p.last.statistics.Get("core", "rcv_requests"); !ok {
+		t.Fatal("expected the statistics of the first poll")
+	}
+	if v := pollSuccess(t, p); v != 1 {
+		t.Errorf("expected the poll to succeed, got %v", v)
+	}
+	polled := p.last.time
+
+	fake.down = true
+	p.poll(context.Background())
+	if _, ok := p.last.statistics.Get("core", "rcv_requests"); !ok {
+		t.Error("expected the statistics of the first poll to be kept after a failed poll")
+	}
+	if !p.last.time.Equal(polled) {
+		t.Error("expected the age of the statistics of the first poll")
+	}
+	if v := pollSuccess(t, p); v != 0 {
+		t.Errorf("expected the failed poll to be reported, got %v", v)
+	}
+
+	fake.down = false
+	p.poll(context.Background())
+	if v := pollSuccess(t, p); v != 1 || !p.last.time.After(polled) {
+		t.Error("expected the statistics of the next successful poll")
+	}
+}
+
+func TestPollFirstFailure(t *testing.T) {
+	fake := &fakeSource{down: true}
+	p := newPoller(newFakeSource(fake, 0, 0), []string{"core:"}, time.Minute)
+	p.poll(context.Background())
+	if _, failed := p.last.errs["core:"]; !failed {
+		t.Error("expected a failed first poll to replace the empty snapshot")
+	}
+	if v := pollSuccess(t, p); v != 0 {
+		t.Errorf("expected the failed poll to be reported, got %v", v)
+	}
+}
+
+func TestPollCheckCollect(t *testing.T) {
+	p := newPoller(newFakeSource(&fakeSource{}, 0, 0), []string{"core:", "tm:"}, time.Minute)
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		{"", true},
+		{"?collect[]=core:&collect[]=tm:", true},
+		{"?collect[]=tm:&collect[]=core:&collect[]=tm:", true},
+		{"?collect[]=core:", false},
+		{"?collect[]=core:&collect[]=tm:&collect[]=sl:", false},
+		{"?collect[]=sl:", false},
+	}
+	for _, tt := range tests {
+		err := p.checkCollect(httptest.NewRequest("GET", "/metrics"+tt.query, nil))
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got error %v", tt.query, tt.valid, err)
+		}
+	}
+}
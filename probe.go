@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/processors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxCachedClients is the maximum number of probe clients kept around. The
+// clients for targets probed after that are created for every probe.
+const maxCachedClients = 256
+
+// maxIdleClient is how long a probe client is kept around after its last
+// probe.
+const maxIdleClient = 10 * time.Minute
+
+// probePaths holds the socket and FIFO paths set with -probe_paths, the only
+// local targets /probe accepts.
+var probePaths = make(map[string]bool)
+
+// checkProbeTarget returns an error when /probe may not use target with
+// protocol. Network targets are allowed, local paths only when they're in
+// probePaths, so that /probe can't be used to reach arbitrary sockets and
+// FIFOs of the host. mi_datagram takes every target but udp:// for a socket
+// path, so only those are network targets for it.
+func checkProbeTarget(protocol, target string) error {
+	url := strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+	udp := strings.HasPrefix(target, "udp://")
+	switch protocol {
+	case "mi_http", "mi_xmlrpc":
+		if !url {
+			return fmt.Errorf("target of %s has to be an http:// or https:// URL", protocol)
+		}
+		return nil
+	case "mi_datagram":
+		if udp {
+			return nil
+		}
+	case opensips.AutoTransport:
+		if url || udp {
+			return nil
+		}
+	}
+	if !probePaths[filepath.Clean(target)] {
+		return fmt.Errorf("target %s isn't one of the -probe_paths", target)
+	}
+	return nil
+}
+
+type clientKey struct {
+	protocol string
+	target   string
+}
+
+// cachedClient is a probe client in the clientCache.
+type cachedClient struct {
+	source *instrumentedSource
+	// users is the number of probes using the client.
+	users    int
+	lastUsed time.Time
+}
+
+// clientCache holds the clients of the probed targets, so that e.g. the
+// detected format of a target is remembered between probes. Clients that
+// weren't used for maxIdleClient are closed.
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[clientKey]*cachedClient
+}
+
+var probeClients = &clientCache{
+	clients: make(map[clientKey]*cachedClient),
+}
+
+// get returns the client for target, creating it when needed. The returned
+// release func has to be called when done with the client.
+func (c *clientCache) get(protocol, target string) (*instrumentedSource, func(), error) {
+	if _, ok := opensips.Transports[protocol]; !ok && protocol != opensips.AutoTransport {
+		return nil, nil, fmt.Errorf("unknown protocol %q", protocol)
+	}
+	key := clientKey{protocol, target}
+	if source, release, ok := c.use(key, nil); ok {
+		return source, release, nil
+	}
+
+	// Creating a client can take a while (e.g. reading the CA file), so it's
+	// done without holding the lock.
+	source, err := newInstrumentedSource(protocol, opensips.Config{
+		Address: target,
+		Format:  miFormat,
+		Timeout: transportTimeout(protocol),
+		HTTP:    httpConfig,
+		FIFO:    fifoConfig,
+	}, processors.NewScrapeStats(), newBreaker(*breakerFailures, *breakerCooldown), commandConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cached, release, ok := c.use(key, source); ok {
+		if cached != source {
+			// Another probe created the client in the meantime.
+			source.Close()
+		}
+		return cached, release, nil
+	}
+	return source, func() { source.Close() }, nil
+}
+
+// use returns the cached client for key, after closing the idle clients.
+// When there's none, source is cached unless the cache is full or source is
+// nil. The returned release func marks the client as unused again.
+func (c *clientCache) use(key clientKey, source *instrumentedSource) (*instrumentedSource, func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, cached := range c.clients {
+		if cached.users == 0 && now.Sub(cached.lastUsed) > maxIdleClient {
+			cached.source.Close()
+			delete(c.clients, k)
+		}
+	}
+	cached, ok := c.clients[key]
+	if !ok {
+		if source == nil || len(c.clients) >= maxCachedClients {
+			return nil, nil, false
+		}
+		cached = &cachedClient{source: source}
+		c.clients[key] = cached
+	}
+	cached.users++
+	release := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		cached.users--
+		cached.lastUsed = time.Now()
+	}
+	return cached.source, release, true
+}
+
+// close closes the cached clients.
+func (c *clientCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, cached := range c.clients {
+		cached.source.Close()
+		delete(c.clients, key)
+	}
+}
+
+// probeHandler serves the metrics of the OpenSIPS given by the target query
+// parameter, e.g. /probe?target=http://10.0.0.5:8888/mi/&protocol=mi_http.
+// The protocol defaults to the one set with the -protocol flag, with auto the
+// transport is detected from the target.
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	target := query.Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	p := query.Get("protocol")
+	if p == "" {
+		p = *protocol
+	}
+	if p == "" {
+		http.Error(w, "Protocol parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if err := checkProbeTarget(p, target); err != nil {
+		http.Error(w, fmt.Sprintf("Target not allowed: %v", err), http.StatusForbidden)
+		return
+	}
+
+	source, release, err := probeClients.get(p, target)
+	if err != nil {
+		log.Printf("Could not create %s client for %s: %v", p, target, err)
+		http.Error(w, fmt.Sprintf("Could not create %s client for %s: %v", p, target, err), http.StatusBadRequest)
+		return
+	}
+	defer release()
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	serveMetrics(w, r, prometheus.Gatherers{scrape(ctx, source, collectGroups(r, source, nil))})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// setProbePaths sets probePaths to paths for the duration of the test.
+func setProbePaths(t *testing.T, paths ...string) {
+	previous := probePaths
+	probePaths = make(map[string]bool)
+	for _, path := range paths {
+		probePaths[path] = true
+	}
+	t.Cleanup(func() { probePaths = previous })
+}
+
+func TestCheckProbeTarget(t *testing.T) {
+	setProbePaths(t, "/var/run/opensips/a.sock", "/tmp/opensips_fifo")
+	tests := []struct {
+		protocol string
+		target   string
+		allowed  bool
+	}{
+		{"mi_http", "http://10.0.0.5:8888/mi/", true},
+		{"mi_http", "https://10.0.0.5:8888/mi/", true},
+		{"mi_http", "udp://10.0.0.5:8080", false},
+		{"mi_http", "/var/run/opensips/a.sock", false},
+		{"mi_xmlrpc", "http://10.0.0.5:8080/RPC2", true},
+		{"mi_xmlrpc", "10.0.0.5:8080", false},
+		{"mi_datagram", "udp://10.0.0.5:8080", true},
+		{"mi_datagram", "/var/run/opensips/a.sock", true},
+		{"mi_datagram", "/var/run/opensips/../opensips/a.sock", true},
+		{"mi_datagram", "/var/run/opensips/b.sock", false},
+		// mi_datagram takes anything but udp:// for a socket path.
+		{"mi_datagram", "http://10.0.0.5/../../var/run/opensips/b.sock", false},
+		{"mi_datagram", "https://10.0.0.5:8888/mi/", false},
+		{"mi_fifo", "/tmp/opensips_fifo", true},
+		{"mi_fifo", "/tmp/other_fifo", false},
+		{"mi_fifo", "udp://10.0.0.5:8080", false},
+		{"auto", "http://10.0.0.5:8888/mi/", true},
+		{"auto", "udp://10.0.0.5:8080", true},
+		{"auto", "/var/run/opensips/a.sock", true},
+		{"auto", "/var/run/opensips/b.sock", false},
+		{"mi_unknown", "http://10.0.0.5:8888/mi/", false},
+		{"mi_unknown", "/var/run/opensips/a.sock", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol+" "+tt.target, func(t *testing.T) {
+			err := checkProbeTarget(tt.protocol, tt.target)
+			if (err == nil) != tt.allowed {
+				t.Errorf("expected allowed %v, got error %v", tt.allowed, err)
+			}
+		})
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	setProbePaths(t, "/var/run/opensips/a.sock")
+	tests := []struct {
+		name     string
+		query    url.Values
+		expected int
+	}{
+		{"without target", url.Values{"protocol": {"mi_http"}}, http.StatusBadRequest},
+		{"forbidden target", url.Values{"target": {"/var/run/opensips/b.sock"}, "protocol": {"mi_datagram"}}, http.StatusForbidden},
+		{"unknown protocol", url.Values{"target": {"/var/run/opensips/a.sock"}, "protocol": {"mi_unknown"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/probe?"+tt.query.Encode(), nil)
+			w := httptest.NewRecorder()
+			probeHandler(w, r)
+			if w.Code != tt.expected {
+				t.Errorf("expected status %d, got %d: %s", tt.expected, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestClientCacheUnknownProtocol(t *testing.T) {
+	c := &clientCache{clients: make(map[clientKey]*cachedClient)}
+	if _, _, err := c.get("mi_unknown", "http://10.0.0.5:8888/mi/"); err == nil {
+		t.Error("expected an error for an unknown protocol")
+	}
+	if len(c.clients) != 0 {
+		t.Errorf("expected no client to be cached, got %d", len(c.clients))
+	}
+}
+
+func TestClientCacheReuse(t *testing.T) {
+	c := &clientCache{clients: make(map[clientKey]*cachedClient)}
+	key := clientKey{"mi_http", "http://10.0.0.5:8888/mi/"}
+	if _, _, ok := c.use(key, nil); ok {
+		t.Fatal("expected no cached client")
+	}
+	first := newFakeSource(&fakeSource{}, 0, 0)
+	source, release, ok := c.use(key, first)
+	if !ok || source != first {
+		t.Fatal("expected the client to be cached")
+	}
+	release()
+	// A client created by a concurrent probe isn't cached when there's one
+	// already.
+	second := newFakeSource(&fakeSource{}, 0, 0)
+	source, release, ok = c.use(key, second)
+	if !ok || source != first {
+		t.Error("expected the cached client to be reused")
+	}
+	release()
+	source, release, ok = c.use(key, nil)
+	if !ok || source != first {
+		t.Error("expected the cached client to be returned")
+	}
+	release()
+	if users := c.clients[key].users; users != 0 {
+		t.Errorf("expected no users after releasing, got %d", users)
+	}
+}
+
+func TestClientCacheFull(t *testing.T) {
+	c := &clientCache{clients: make(map[clientKey]*cachedClient)}
+	for i := 0; i < maxCachedClients; i++ {
+		key := clientKey{"mi_http", fmt.Sprintf("http://10.0.0.%d:8888/mi/", i)}
+		if _, _, ok := c.use(key, newFakeSource(&fakeSource{}, 0, 0)); !ok {
+			t.Fatalf("expected client %d to be cached", i)
+		}
+	}
+	key := clientKey{"mi_http", "http://10.0.1.1:8888/mi/"}
+	if _, _, ok := c.use(key, newFakeSource(&fakeSource{}, 0, 0)); ok {
+		t.Error("expected no client to be cached when the cache is full")
+	}
+	if len(c.clients) != maxCachedClients {
+		t.Errorf("expected %d clients, got %d", maxCachedClients, len(c.clients))
+	}
+}
+
+func TestClientCacheIdle(t *testing.T) {
+	c := &clientCache{clients: make(map[clientKey]*cachedClient)}
+	idleKey := clientKey{"mi_http", "http://10.0.0.5:8888/mi/"}
+	busyKey := clientKey{"mi_http", "http://10.0.0.6:8888/mi/"}
+	idle, busy := &fakeSource{}, &fakeSource{}
+	_, release, _ := c.use(idleKey, newFakeSource(idle, 0, 0))
+	release()
+	_, releaseBusy, _ := c.use(busyKey, newFakeSource(busy, 0, 0))
+	defer releaseBusy()
+	// Both clients were last used long ago, but a probe is still using the
+	// busy one.
+	for _, cached := range c.clients {
+		cached.lastUsed = time.Now().Add(-2 * maxIdleClient)
+	}
+
+	c.use(clientKey{"mi_http", "http://10.0.0.7:8888/mi/"}, nil)
+	if _, ok := c.clients[idleKey]; ok || !idle.closed {
+		t.Error("expected the idle client to be closed and removed")
+	}
+	if _, ok := c.clients[busyKey]; !ok || busy.closed {
+		t.Error("expected the client in use to be kept")
+	}
+
+	c.close()
+	if len(c.clients) != 0 || !busy.closed {
+		t.Error("expected close to close all clients")
+	}
+}
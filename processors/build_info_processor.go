@@ -0,0 +1,99 @@
+package processors
+
+import (
+	"context"
+	"regexp"
+	"sync"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// buildInfoProcessor exports the version and build of OpenSIPS, read with the
+// version management function. The reply is cached until core:timestamp goes
+// backwards, i.e. until OpenSIPS restarts.
+// doc: https://www.opensips.org/Documentation/Interface-CoreMI-3-1#toc24
+type buildInfoProcessor struct {
+	mu     sync.Mutex
+	uptime float64
+	info   *buildInfo
+}
+
+// buildInfo holds the fields of the reply to the version management function.
+type buildInfo struct {
+	version     string
+	flags       string
+	compileTime string
+	server      string
+}
+
+var buildInfoMetric = newMetric("", "build_info", "OpenSIPS version and build information, from the version management function.", []string{"version", "flags", "compile_time", "server"}, prometheus.GaugeValue)
+
+// compiledOnRE matches the Build field of OpenSIPS >= 2.x, e.g. "compiled on
+// 12:21:51 Jan 29 2019 with gcc 6.3.0".
+var compiledOnRE = regexp.MustCompile(`^compiled on (.*?)(?: with .*)?$`)
+
+func init() {
+	CommandProcessors["build_info"] = func(CommandConfig) CommandProcessor {
+		return &buildInfoProcessor{}
+	}
+}
+
+// Collector implements CommandProcessor.
+func (p *buildInfoProcessor) Collector(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Without core:timestamp a restart can't be noticed, so version is
+	// called every time.
+	uptime, ok := statistics.Get("core", "timestamp")
+	if !ok || uptime.Value < p.uptime {
+		p.info = nil
+	}
+	if ok {
+		p.uptime = uptime.Value
+	}
+	if p.info == nil {
+		reply, err := c.Call(ctx, "version")
+		if err != nil {
+			return nil, err
+		}
+		p.info = parseBuildInfo(reply)
+	}
+	return buildInfoCollector(*p.info), nil
+}
+
+// parseBuildInfo reads the reply to the version management function, both in
+// the text and the JSON-RPC format.
+func parseBuildInfo(reply interface{}) *buildInfo {
+	info := &buildInfo{
+		version:     opensips.ServerVersion(reply),
+		flags:       opensips.ReplyValue(reply, "Flags"),
+		compileTime: opensips.ReplyValue(reply, "Build"),
+		server:      opensips.ReplyValue(reply, "Server"),
+	}
+	if m := compiledOnRE.FindStringSubmatch(info.compileTime); m != nil {
+		info.compileTime = m[1]
+	}
+	return info
+}
+
+type buildInfoCollector buildInfo
+
+// Describe implements prometheus.Collector.
+func (i buildInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- buildInfoMetric.Desc
+}
+
+// Collect implements prometheus.Collector.
+func (i buildInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		buildInfoMetric.Desc,
+		buildInfoMetric.ValueType,
+		1,
+		i.version,
+		i.flags,
+		i.compileTime,
+		i.server,
+	)
+}
@@ -0,0 +1,122 @@
+package processors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+)
+
+func TestParseBuildInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected buildInfo
+	}{
+		{
+			name: "1.x",
+			response: "200 OK\n" +
+				"Server:: OpenSIPS (1.11.10-notls (x86_64/linux))\n" +
+				"Build:: 10:56:47 Jan  2 2018\n" +
+				"Flags:: STATS: On, SHM_MEMORY, USE_IPV6, F_MALLOC\n" +
+				"GIT revision:: 5c8ef2b\n\n",
+			expected: buildInfo{
+				version:     "1.11.10-notls",
+				flags:       "STATS: On, SHM_MEMORY, USE_IPV6, F_MALLOC",
+				compileTime: "10:56:47 Jan 2 2018",
+				server:      "OpenSIPS (1.11.10-notls (x86_64/linux))",
+			},
+		},
+		{
+			name: "2.x",
+			response: "200 OK\n" +
+				"Server:: OpenSIPS (2.4.5 (x86_64/linux))\n" +
+				"Build:: compiled on 12:21:51 Jan 29 2019 with gcc 6.3.0\n" +
+				"Flags:: STATS: On, DISABLE_NAGLE, USE_MCAST, SHM_MMAP, PKG_MALLOC, F_MALLOC, FAST_LOCK-ADAPTIVE_WAIT\n" +
+				"GIT revision:: 9a5e1ef\n\n",
+			expected: buildInfo{
+				version:     "2.4.5",
+				flags:       "STATS: On, DISABLE_NAGLE, USE_MCAST, SHM_MMAP, PKG_MALLOC, F_MALLOC, FAST_LOCK-ADAPTIVE_WAIT",
+				compileTime: "12:21:51 Jan 29 2019",
+				server:      "OpenSIPS (2.4.5 (x86_64/linux))",
+			},
+		},
+		{
+			name: "3.x",
+			response: `{"jsonrpc":"2.0","result":{` +
+				`"Server":"OpenSIPS (3.1.0 (x86_64/linux))",` +
+				`"Build":"compiled on 13:37:02 Jun 10 2020 with gcc 8",` +
+				`"Flags":"STATS: On, DISABLE_NAGLE, USE_MCAST, SHM_MMAP, PKG_MALLOC, Q_MALLOC, F_MALLOC, HP_MALLOC, DBG_MALLOC, FAST_LOCK-ADAPTIVE_WAIT",` +
+				`"GIT revision":"3f5ae0a0c"},"id":1}`,
+			expected: buildInfo{
+				version:     "3.1.0",
+				flags:       "STATS: On, DISABLE_NAGLE, USE_MCAST, SHM_MMAP, PKG_MALLOC, Q_MALLOC, F_MALLOC, HP_MALLOC, DBG_MALLOC, FAST_LOCK-ADAPTIVE_WAIT",
+				compileTime: "13:37:02 Jun 10 2020",
+				server:      "OpenSIPS (3.1.0 (x86_64/linux))",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := parseBuildInfo(recordedReply(t, tt.response, "version"))
+			if *info != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *info)
+			}
+		})
+	}
+}
+
+func TestCompiledOnRE(t *testing.T) {
+	tests := []struct {
+		build    string
+		expected string
+	}{
+		{"compiled on 12:21:51 Jan 29 2019 with gcc 6.3.0", "12:21:51 Jan 29 2019"},
+		{"compiled on 12:21:51 Jan 29 2019", "12:21:51 Jan 29 2019"},
+		{"compiled on 08:00:00 Mar  1 2021 with cc 10", "08:00:00 Mar  1 2021"},
+		{"12:21:51 Jan 29 2019", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		var compileTime string
+		if m := compiledOnRE.FindStringSubmatch(tt.build); m != nil {
+			compileTime = m[1]
+		}
+		if compileTime != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.build, tt.expected, compileTime)
+		}
+	}
+}
+
+func TestBuildInfoCache(t *testing.T) {
+	c := &fakeCaller{replies: map[string]interface{}{
+		"version": map[string]interface{}{"Server": "OpenSIPS (3.1.0 (x86_64/linux))"},
+	}}
+	timestamp := func(v float64) opensips.Statistics {
+		s := make(opensips.Statistics)
+		s.Add(opensips.Statistic{Module: "core", Name: "timestamp", Value: v})
+		return s
+	}
+	tests := []struct {
+		name       string
+		statistics opensips.Statistics
+		calls      int
+	}{
+		{"first scrape", timestamp(100), 1},
+		{"uptime increased", timestamp(160), 1},
+		{"same uptime", timestamp(160), 1},
+		{"restarted", timestamp(5), 2},
+		{"after restart", timestamp(65), 2},
+		{"without timestamp", make(opensips.Statistics), 3},
+		{"without timestamp again", make(opensips.Statistics), 4},
+	}
+	p := CommandProcessors["build_info"](CommandConfig{})
+	for _, tt := range tests {
+		if _, err := p.Collector(context.Background(), c, tt.statistics); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(c.calls) != tt.calls {
+			t.Errorf("%s: expected version to be called %d times, got %d", tt.name, tt.calls, len(c.calls))
+		}
+	}
+}
@@ -9,7 +9,7 @@ import (
 // doc: http://www.opensips.org/Documentation/Interface-CoreStatistics-1-11#toc1
 // src: https://github.com/OpenSIPS/opensips/blob/1.11/core_stats.h
 type coreProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 var coreMetrics = map[string]metric{
@@ -28,13 +28,11 @@ var coreMetrics = map[string]metric{
 }
 
 func init() {
-	for metric := range coreMetrics {
-		OpensipsProcessors[metric] = coreProcessorFunc
-	}
 	OpensipsProcessors["core:"] = coreProcessorFunc
+	knownStatistics["core"] = knownMetrics(coreMetrics)
 }
 
-func coreProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func coreProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &coreProcessor{
 		statistics: s,
 	}
@@ -49,88 +47,86 @@ func (p coreProcessor) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (p coreProcessor) Collect(ch chan<- prometheus.Metric) {
-	for _, s := range p.statistics {
-		if s.Module == "core" {
-			switch s.Name {
-			case "rcv_requests":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["rcv_requests"].Desc,
-					coreMetrics["rcv_requests"].ValueType,
-					s.Value,
-				)
-			case "rcv_replies":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["rcv_replies"].Desc,
-					coreMetrics["rcv_replies"].ValueType,
-					s.Value,
-				)
-			case "fwd_requests":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["fwd_requests"].Desc,
-					coreMetrics["fwd_requests"].ValueType,
-					s.Value,
-					"forwarded",
-				)
-			case "fwd_replies":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["fwd_replies"].Desc,
-					coreMetrics["fwd_replies"].ValueType,
-					s.Value,
-					"forwarded",
-				)
-			case "drop_requests":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["drop_requests"].Desc,
-					coreMetrics["drop_requests"].ValueType,
-					s.Value,
-					"dropped",
-				)
-			case "drop_replies":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["drop_replies"].Desc,
-					coreMetrics["drop_replies"].ValueType,
-					s.Value,
-					"dropped",
-				)
-			case "err_requests":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["err_requests"].Desc,
-					coreMetrics["err_requests"].ValueType,
-					s.Value,
-					"error",
-				)
-			case "err_replies":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["err_replies"].Desc,
-					coreMetrics["err_replies"].ValueType,
-					s.Value,
-					"error",
-				)
-			case "bad_URIs_rcvd":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["bad_URIs_rcvd"].Desc,
-					coreMetrics["bad_URIs_rcvd"].ValueType,
-					s.Value,
-				)
-			case "unsupported_methods":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["unsupported_methods"].Desc,
-					coreMetrics["unsupported_methods"].ValueType,
-					s.Value,
-				)
-			case "bad_msg_hdr":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["bad_msg_hdr"].Desc,
-					coreMetrics["bad_msg_hdr"].ValueType,
-					s.Value,
-				)
-			case "timestamp":
-				ch <- prometheus.MustNewConstMetric(
-					coreMetrics["timestamp"].Desc,
-					coreMetrics["timestamp"].ValueType,
-					s.Value,
-				)
-			}
+	for _, s := range p.statistics.Module("core") {
+		switch s.Name {
+		case "rcv_requests":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["rcv_requests"].Desc,
+				coreMetrics["rcv_requests"].ValueType,
+				s.Value,
+			)
+		case "rcv_replies":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["rcv_replies"].Desc,
+				coreMetrics["rcv_replies"].ValueType,
+				s.Value,
+			)
+		case "fwd_requests":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["fwd_requests"].Desc,
+				coreMetrics["fwd_requests"].ValueType,
+				s.Value,
+				"forwarded",
+			)
+		case "fwd_replies":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["fwd_replies"].Desc,
+				coreMetrics["fwd_replies"].ValueType,
+				s.Value,
+				"forwarded",
+			)
+		case "drop_requests":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["drop_requests"].Desc,
+				coreMetrics["drop_requests"].ValueType,
+				s.Value,
+				"dropped",
+			)
+		case "drop_replies":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["drop_replies"].Desc,
+				coreMetrics["drop_replies"].ValueType,
+				s.Value,
+				"dropped",
+			)
+		case "err_requests":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["err_requests"].Desc,
+				coreMetrics["err_requests"].ValueType,
+				s.Value,
+				"error",
+			)
+		case "err_replies":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["err_replies"].Desc,
+				coreMetrics["err_replies"].ValueType,
+				s.Value,
+				"error",
+			)
+		case "bad_URIs_rcvd":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["bad_URIs_rcvd"].Desc,
+				coreMetrics["bad_URIs_rcvd"].ValueType,
+				s.Value,
+			)
+		case "unsupported_methods":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["unsupported_methods"].Desc,
+				coreMetrics["unsupported_methods"].ValueType,
+				s.Value,
+			)
+		case "bad_msg_hdr":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["bad_msg_hdr"].Desc,
+				coreMetrics["bad_msg_hdr"].ValueType,
+				s.Value,
+			)
+		case "timestamp":
+			ch <- prometheus.MustNewConstMetric(
+				coreMetrics["timestamp"].Desc,
+				coreMetrics["timestamp"].ValueType,
+				s.Value,
+			)
 		}
 	}
 }
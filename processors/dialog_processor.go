@@ -9,7 +9,7 @@ import (
 // doc: http://www.opensips.org/html/docs/modules/1.11.x/dialog.html#idp5859728
 // src: https://github.com/OpenSIPS/opensips/blob/1.11/modules/dialog/dialog.c#L283
 type dialogProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 var dialogLabelNames = []string{}
@@ -28,10 +28,8 @@ var dialogMetrics = map[string]metric{
 }
 
 func init() {
-	for metric := range dialogMetrics {
-		OpensipsProcessors[metric] = dialogProcessorFunc
-	}
 	OpensipsProcessors["dialog:"] = dialogProcessorFunc
+	knownStatistics["dialog"] = knownMetrics(dialogMetrics)
 }
 
 // Describe implements prometheus.Collector.
@@ -43,92 +41,90 @@ func (p dialogProcessor) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (p dialogProcessor) Collect(ch chan<- prometheus.Metric) {
-	for _, s := range p.statistics {
-		if s.Module == "dialog" {
-			switch s.Name {
-			case "active_dialogs":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["active_dialogs"].Desc,
-					dialogMetrics["active_dialogs"].ValueType,
-					s.Value,
-					"active",
-				)
-			case "early_dialogs":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["early_dialogs"].Desc,
-					dialogMetrics["early_dialogs"].ValueType,
-					s.Value,
-					"early",
-				)
-			case "processed_dialogs":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["processed_dialogs"].Desc,
-					dialogMetrics["processed_dialogs"].ValueType,
-					s.Value,
-					"processed",
-				)
-			case "expired_dialogs":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["expired_dialogs"].Desc,
-					dialogMetrics["expired_dialogs"].ValueType,
-					s.Value,
-					"expired",
-				)
-			case "failed_dialogs":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["failed_dialogs"].Desc,
-					dialogMetrics["failed_dialogs"].ValueType,
-					s.Value,
-					"failed",
-				)
-			case "create_sent":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["create_sent"].Desc,
-					dialogMetrics["create_sent"].ValueType,
-					s.Value,
-					"create",
-				)
-			case "update_sent":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["update_sent"].Desc,
-					dialogMetrics["update_sent"].ValueType,
-					s.Value,
-					"update",
-				)
-			case "delete_sent":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["delete_sent"].Desc,
-					dialogMetrics["delete_sent"].ValueType,
-					s.Value,
-					"delete",
-				)
-			case "create_rcv":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["create_rcv"].Desc,
-					dialogMetrics["create_rcv"].ValueType,
-					s.Value,
-					"create",
-				)
-			case "update_rcv":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["update_rcv"].Desc,
-					dialogMetrics["update_rcv"].ValueType,
-					s.Value,
-					"update",
-				)
-			case "delete_rcv":
-				ch <- prometheus.MustNewConstMetric(
-					dialogMetrics["delete_rcv"].Desc,
-					dialogMetrics["delete_rcv"].ValueType,
-					s.Value,
-					"delete",
-				)
-			}
+	for _, s := range p.statistics.Module("dialog") {
+		switch s.Name {
+		case "active_dialogs":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["active_dialogs"].Desc,
+				dialogMetrics["active_dialogs"].ValueType,
+				s.Value,
+				"active",
+			)
+		case "early_dialogs":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["early_dialogs"].Desc,
+				dialogMetrics["early_dialogs"].ValueType,
+				s.Value,
+				"early",
+			)
+		case "processed_dialogs":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["processed_dialogs"].Desc,
+				dialogMetrics["processed_dialogs"].ValueType,
+				s.Value,
+				"processed",
+			)
+		case "expired_dialogs":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["expired_dialogs"].Desc,
+				dialogMetrics["expired_dialogs"].ValueType,
+				s.Value,
+				"expired",
+			)
+		case "failed_dialogs":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["failed_dialogs"].Desc,
+				dialogMetrics["failed_dialogs"].ValueType,
+				s.Value,
+				"failed",
+			)
+		case "create_sent":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["create_sent"].Desc,
+				dialogMetrics["create_sent"].ValueType,
+				s.Value,
+				"create",
+			)
+		case "update_sent":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["update_sent"].Desc,
+				dialogMetrics["update_sent"].ValueType,
+				s.Value,
+				"update",
+			)
+		case "delete_sent":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["delete_sent"].Desc,
+				dialogMetrics["delete_sent"].ValueType,
+				s.Value,
+				"delete",
+			)
+		case "create_rcv":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["create_rcv"].Desc,
+				dialogMetrics["create_rcv"].ValueType,
+				s.Value,
+				"create",
+			)
+		case "update_rcv":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["update_rcv"].Desc,
+				dialogMetrics["update_rcv"].ValueType,
+				s.Value,
+				"update",
+			)
+		case "delete_rcv":
+			ch <- prometheus.MustNewConstMetric(
+				dialogMetrics["delete_rcv"].Desc,
+				dialogMetrics["delete_rcv"].ValueType,
+				s.Value,
+				"delete",
+			)
 		}
 	}
 }
 
-func dialogProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func dialogProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &dialogProcessor{
 		statistics: s,
 	}
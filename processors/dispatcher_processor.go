@@ -0,0 +1,210 @@
+package processors
+
+import (
+	"context"
+	"strings"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dispatcherProcessor exports the state, weight and priority of the
+// destinations of the dispatcher module, read with the ds_list management
+// function.
+// doc: https://opensips.org/html/docs/modules/3.1.x/dispatcher.html
+// doc: https://opensips.org/html/docs/modules/2.4.x/dispatcher.html
+type dispatcherProcessor struct {
+	dsList callVariants
+}
+
+// dsDestination is a destination in the reply to ds_list.
+type dsDestination struct {
+	partition, set, uri, state string
+	weight, priority           float64
+	hasWeight, hasPriority     bool
+}
+
+// dsStates are the states of a destination, in the format of the state label.
+var dsStates = []string{"active", "inactive", "probing"}
+
+var dispatcherLabelNames = []string{"partition", "set", "uri"}
+
+var dispatcherMetrics = map[string]metric{
+	"state":        newMetric("dispatcher", "destination_state", "Whether the state of the destination is the one in the state label.", []string{"partition", "set", "uri", "state"}, prometheus.GaugeValue),
+	"weight":       newMetric("dispatcher", "destination_weight", "Weight of the destination.", dispatcherLabelNames, prometheus.GaugeValue),
+	"priority":     newMetric("dispatcher", "destination_priority", "Priority of the destination.", dispatcherLabelNames, prometheus.GaugeValue),
+	"destinations": newMetric("dispatcher", "destinations", "Number of destinations in the set in the state.", []string{"partition", "set", "state"}, prometheus.GaugeValue),
+}
+
+func init() {
+	CommandProcessors["dispatcher"] = func(CommandConfig) CommandProcessor {
+		return &dispatcherProcessor{
+			dsList: callVariants{
+				method: "ds_list",
+				// The weight and priority are only listed in full, which is
+				// requested with "full" up to OpenSIPS 2.4 and with 1 since
+				// 3.0. OpenSIPS 1.x doesn't know the parameter.
+				variants: [][]interface{}{{"full"}, {1}, nil},
+			},
+		}
+	}
+}
+
+// Collector implements CommandProcessor.
+func (p *dispatcherProcessor) Collector(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error) {
+	reply, err := p.dsList.call(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return &dispatcherCollector{destinations: parseDispatcher(reply)}, nil
+}
+
+// parseDispatcher reads the destinations from the reply to ds_list. Replies
+// without partitions (OpenSIPS < 2.1) are in the default partition.
+func parseDispatcher(reply interface{}) []dsDestination {
+	var destinations []dsDestination
+	seen := make(map[[3]string]bool)
+	add := func(d dsDestination) {
+		key := [3]string{d.partition, d.set, d.uri}
+		if d.uri == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		destinations = append(destinations, d)
+	}
+
+	if r, ok := reply.(map[string]interface{}); ok {
+		for _, p := range replyList(r["PARTITIONS"]) {
+			partition := replyMap(p)
+			for _, s := range replyList(partition["SETS"]) {
+				set := replyMap(s)
+				for _, dst := range replyList(set["Destinations"]) {
+					d := replyMap(dst)
+					destination := dsDestination{
+						partition: replyString(partition["name"]),
+						set:       replyString(set["id"]),
+						uri:       replyString(d["URI"]),
+						state:     dsState(replyString(d["state"])),
+					}
+					destination.weight, destination.hasWeight = replyFloat(d["weight"])
+					destination.priority, destination.hasPriority = replyFloat(d["priority"])
+					add(destination)
+				}
+			}
+		}
+		return destinations
+	}
+
+	sets := func(partition string, nodes interface{}) {
+		for _, set := range textNodes(nodes, "SET") {
+			for _, d := range textNodes(set[opensips.TextNodeChildren], "URI") {
+				destination := dsDestination{
+					partition: partition,
+					set:       replyString(set[opensips.TextNodeValue]),
+					uri:       replyString(d[opensips.TextNodeValue]),
+				}
+				if state, ok := textNodeField(d, "state"); ok {
+					destination.state = dsState(replyString(state))
+				} else if flags, ok := textNodeField(d, "flags"); ok {
+					destination.state = dsFlagsState(replyString(flags))
+				}
+				if v, ok := textNodeField(d, "weight"); ok {
+					destination.weight, destination.hasWeight = replyFloat(v)
+				}
+				if v, ok := textNodeField(d, "priority"); ok {
+					destination.priority, destination.hasPriority = replyFloat(v)
+				}
+				add(destination)
+			}
+		}
+	}
+	sets("default", reply)
+	for _, partition := range textNodes(reply, "PARTITION") {
+		sets(replyString(partition[opensips.TextNodeValue]), partition[opensips.TextNodeChildren])
+	}
+	return destinations
+}
+
+// dsState returns the state label for the state of a destination, e.g.
+// "active" for "Active".
+func dsState(state string) string {
+	return strings.ToLower(strings.TrimSpace(state))
+}
+
+// dsFlagsState returns the state label for the flags of a destination as
+// listed by old versions of OpenSIPS, e.g. "AP" for an active destination
+// that is probed.
+func dsFlagsState(flags string) string {
+	switch {
+	case strings.ContainsAny(flags, "ID"):
+		return "inactive"
+	case strings.Contains(flags, "P"):
+		return "probing"
+	}
+	return "active"
+}
+
+type dispatcherCollector struct {
+	destinations []dsDestination
+}
+
+// Describe implements prometheus.Collector.
+func (c *dispatcherCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range dispatcherMetrics {
+		ch <- m.Desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *dispatcherCollector) Collect(ch chan<- prometheus.Metric) {
+	type set struct{ partition, set string }
+	var sets []set
+	counts := make(map[set]map[string]int)
+	for _, d := range c.destinations {
+		s := set{d.partition, d.set}
+		if counts[s] == nil {
+			counts[s] = make(map[string]int)
+			sets = append(sets, s)
+		}
+		counts[s][d.state]++
+
+		for _, state := range dsStates {
+			var v float64
+			if d.state == state {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				dispatcherMetrics["state"].Desc,
+				dispatcherMetrics["state"].ValueType,
+				v,
+				d.partition, d.set, d.uri, state,
+			)
+		}
+		if d.hasWeight {
+			ch <- prometheus.MustNewConstMetric(
+				dispatcherMetrics["weight"].Desc,
+				dispatcherMetrics["weight"].ValueType,
+				d.weight,
+				d.partition, d.set, d.uri,
+			)
+		}
+		if d.hasPriority {
+			ch <- prometheus.MustNewConstMetric(
+				dispatcherMetrics["priority"].Desc,
+				dispatcherMetrics["priority"].ValueType,
+				d.priority,
+				d.partition, d.set, d.uri,
+			)
+		}
+	}
+	for _, s := range sets {
+		for _, state := range dsStates {
+			ch <- prometheus.MustNewConstMetric(
+				dispatcherMetrics["destinations"].Desc,
+				dispatcherMetrics["destinations"].ValueType,
+				float64(counts[s][state]),
+				s.partition, s.set, state,
+			)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+)
+
+func TestParseDispatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected []dsDestination
+	}{
+		{
+			name: "1.x flags",
+			response: "200 OK\n" +
+				"SET:: 1\n" +
+				"\tURI:: sip:10.0.0.1:5060 flags=A priority=0 attrs=\n" +
+				"\tURI:: sip:10.0.0.2:5060 flags=AP priority=1 attrs=\n" +
+				"SET:: 2\n" +
+				"\tURI:: sip:10.0.0.3:5060 flags=IP priority=0 attrs=\n" +
+				"\tURI:: sip:10.0.0.4:5060 flags=DX priority=0 attrs=\n\n",
+			expected: []dsDestination{
+				{partition: "default", set: "1", uri: "sip:10.0.0.1:5060", state: "active", hasPriority: true},
+				{partition: "default", set: "1", uri: "sip:10.0.0.2:5060", state: "probing", priority: 1, hasPriority: true},
+				{partition: "default", set: "2", uri: "sip:10.0.0.3:5060", state: "inactive", hasPriority: true},
+				{partition: "default", set: "2", uri: "sip:10.0.0.4:5060", state: "inactive", hasPriority: true},
+			},
+		},
+		{
+			name: "2.x partitions",
+			response: "200 OK\n" +
+				"PARTITION:: default\n" +
+				"\tSET:: 1\n" +
+				"\t\tURI:: sip:10.0.0.1:5060 state=Active first_hit_counter=3\n" +
+				"\t\t\tweight:: 1\n" +
+				"\t\t\tpriority:: 0\n" +
+				"\t\tURI:: sip:10.0.0.2:5060 state=Inactive first_hit_counter=0\n" +
+				"\t\t\tweight:: 2\n" +
+				"\t\t\tpriority:: 1\n" +
+				"PARTITION:: edge\n" +
+				"\tSET:: 1\n" +
+				"\t\tURI:: sip:10.0.1.1:5060 state=Probing first_hit_counter=0\n" +
+				"\t\t\tweight:: 1\n" +
+				"\t\t\tpriority:: 0\n\n",
+			expected: []dsDestination{
+				{partition: "default", set: "1", uri: "sip:10.0.0.1:5060", state: "active", weight: 1, hasWeight: true, hasPriority: true},
+				{partition: "default", set: "1", uri: "sip:10.0.0.2:5060", state: "inactive", weight: 2, hasWeight: true, priority: 1, hasPriority: true},
+				{partition: "edge", set: "1", uri: "sip:10.0.1.1:5060", state: "probing", weight: 1, hasWeight: true, hasPriority: true},
+			},
+		},
+		{
+			name: "3.x",
+			response: `{"jsonrpc":"2.0","result":{"PARTITIONS":[` +
+				`{"name":"default","SETS":[{"id":1,"Destinations":[` +
+				`{"URI":"sip:10.0.0.1:5060","state":"Active","first_hit_counter":3,"weight":1,"priority":0},` +
+				`{"URI":"sip:10.0.0.2:5060","state":"Inactive","first_hit_counter":0,"weight":2,"priority":1}]}]},` +
+				`{"name":"edge","SETS":[{"id":1,"Destinations":[` +
+				`{"URI":"sip:10.0.1.1:5060","state":"Probing","first_hit_counter":0,"weight":1,"priority":0}]}]}` +
+				`]},"id":1}`,
+			expected: []dsDestination{
+				{partition: "default", set: "1", uri: "sip:10.0.0.1:5060", state: "active", weight: 1, hasWeight: true, hasPriority: true},
+				{partition: "default", set: "1", uri: "sip:10.0.0.2:5060", state: "inactive", weight: 2, hasWeight: true, priority: 1, hasPriority: true},
+				{partition: "edge", set: "1", uri: "sip:10.0.1.1:5060", state: "probing", weight: 1, hasWeight: true, hasPriority: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destinations := parseDispatcher(recordedReply(t, tt.response, "ds_list"))
+			if !reflect.DeepEqual(destinations, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, destinations)
+			}
+		})
+	}
+}
+
+func TestCallVariants(t *testing.T) {
+	unknownParameter := &opensips.ReplyError{Code: 400, Message: "Too many parameters"}
+	tests := []struct {
+		name     string
+		errs     map[string]error
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "first variant",
+			expected: []string{"ds_list full", "ds_list full"},
+		},
+		{
+			name:     "fall back",
+			errs:     map[string]error{"ds_list full": unknownParameter},
+			expected: []string{"ds_list full", "ds_list 1", "ds_list 1"},
+		},
+		{
+			name:     "fall back to no parameters",
+			errs:     map[string]error{"ds_list full": unknownParameter, "ds_list 1": unknownParameter},
+			expected: []string{"ds_list full", "ds_list 1", "ds_list", "ds_list"},
+		},
+		{
+			name:     "other errors",
+			errs:     map[string]error{"ds_list full": errors.New("connection refused")},
+			expected: []string{"ds_list full", "ds_list full"},
+			err:      true,
+		},
+		{
+			name: "every variant rejected",
+			errs: map[string]error{
+				"ds_list full": unknownParameter,
+				"ds_list 1":    unknownParameter,
+				"ds_list":      unknownParameter,
+			},
+			expected: []string{"ds_list full", "ds_list 1", "ds_list", "ds_list full", "ds_list 1", "ds_list"},
+			err:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeCaller{errs: tt.errs}
+			v := &callVariants{method: "ds_list", variants: [][]interface{}{{"full"}, {1}, nil}}
+			for i := 0; i < 2; i++ {
+				if _, err := v.call(context.Background(), c); (err != nil) != tt.err {
+					t.Errorf("expected error %v, got %v", tt.err, err)
+				}
+			}
+			if strings.Join(c.calls, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("expected calls %q, got %q", tt.expected, c.calls)
+			}
+		})
+	}
+}
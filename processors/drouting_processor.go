@@ -0,0 +1,219 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// droutingProcessor exports the state of the gateways and carriers of the
+// dynamic routing module, read with the dr_gw_status and dr_carrier_status
+// management functions.
+// doc: https://opensips.org/html/docs/modules/3.1.x/drouting.html
+// doc: https://opensips.org/html/docs/modules/2.4.x/drouting.html
+type droutingProcessor struct {
+	// partitions are the partitions to read, see
+	// CommandConfig.DroutingPartitions.
+	partitions []string
+
+	mu sync.Mutex
+	// calls holds the calls per management function and partition.
+	calls map[[2]string]*callVariants
+}
+
+// drEntry is a gateway or carrier in the reply to dr_gw_status or
+// dr_carrier_status. Carriers have no address.
+type drEntry struct {
+	partition, id, address, state string
+}
+
+// drStates are the states of a gateway or carrier, in the format of the state
+// label. States that aren't known are reported as unknown.
+var drStates = []string{"enabled", "disabled", "probing", "unknown"}
+
+var droutingMetrics = map[string]metric{
+	"gateway_state": newMetric("drouting", "gateway_state", "Whether the state of the gateway is the one in the state label.", []string{"partition", "id", "address", "state"}, prometheus.GaugeValue),
+	"carrier_state": newMetric("drouting", "carrier_state", "Whether the state of the carrier is the one in the state label.", []string{"partition", "id", "state"}, prometheus.GaugeValue),
+	"gateways":      newMetric("drouting", "gateways", "Number of gateways in the partition in the state.", []string{"partition", "state"}, prometheus.GaugeValue),
+	"carriers":      newMetric("drouting", "carriers", "Number of carriers in the partition in the state.", []string{"partition", "state"}, prometheus.GaugeValue),
+}
+
+func init() {
+	CommandProcessors["drouting"] = func(c CommandConfig) CommandProcessor {
+		return &droutingProcessor{
+			partitions: c.DroutingPartitions,
+			calls:      make(map[[2]string]*callVariants),
+		}
+	}
+}
+
+// Collector implements CommandProcessor.
+func (p *droutingProcessor) Collector(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error) {
+	partitions := p.partitions
+	if len(partitions) == 0 {
+		partitions = []string{""}
+	}
+	collector := &droutingCollector{}
+	for _, partition := range partitions {
+		reply, err := p.call(partition, "dr_gw_status").call(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		collector.gateways = append(collector.gateways, parseDrouting(reply, partition, "Gateways")...)
+
+		reply, err = p.call(partition, "dr_carrier_status").call(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		collector.carriers = append(collector.carriers, parseDrouting(reply, partition, "Carriers")...)
+	}
+	return collector, nil
+}
+
+// call returns the call of the management function method for partition.
+func (p *droutingProcessor) call(partition, method string) *callVariants {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := [2]string{partition, method}
+	if v, ok := p.calls[key]; ok {
+		return v
+	}
+	v := &callVariants{method: method, variants: [][]interface{}{nil}}
+	if partition != "" {
+		// Since OpenSIPS 3.0 the partition is a parameter of its own, before
+		// it was the first parameter with a trailing colon.
+		v.variants = [][]interface{}{{partition}, {partition + ":"}}
+	}
+	p.calls[key] = v
+	return v
+}
+
+// parseDrouting reads the gateways or carriers from the reply to dr_gw_status
+// or dr_carrier_status. In the JSON-RPC format they're in the list field, in
+// the text format every one is an ID node, possibly in a Partition node.
+// Without partition, they're in the default partition.
+func parseDrouting(reply interface{}, partition, list string) []drEntry {
+	if partition == "" {
+		partition = "default"
+	}
+	var entries []drEntry
+	seen := make(map[[2]string]bool)
+	add := func(e drEntry) {
+		key := [2]string{e.partition, e.id}
+		if e.id == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		entries = append(entries, e)
+	}
+
+	if r, ok := reply.(map[string]interface{}); ok {
+		for _, e := range replyList(replyField(r, list)) {
+			m := replyMap(e)
+			add(drEntry{
+				partition: partition,
+				id:        replyString(replyField(m, "ID")),
+				address:   replyString(replyField(m, "IP Address", "IP", "Address")),
+				state:     drState(replyString(replyField(m, "State", "Enabled", "Status"))),
+			})
+		}
+		return entries
+	}
+
+	ids := func(partition string, nodes interface{}) {
+		for _, n := range textNodes(nodes, "ID") {
+			e := drEntry{
+				partition: partition,
+				id:        replyString(n[opensips.TextNodeValue]),
+			}
+			if v, ok := textNodeField(n, "IP", "Address"); ok {
+				e.address = replyString(v)
+			}
+			state, _ := textNodeField(n, "State", "Enabled", "Status")
+			e.state = drState(replyString(state))
+			add(e)
+		}
+	}
+	ids(partition, reply)
+	for _, n := range textNodes(reply, "Partition") {
+		ids(replyString(n[opensips.TextNodeValue]), n[opensips.TextNodeChildren])
+	}
+	return entries
+}
+
+// drState returns the state label for the state of a gateway or carrier,
+// e.g. "enabled" for "yes" or "Active", "disabled" for "no (disabled)" or
+// "Inactive", and "unknown" for states it doesn't know.
+func drState(state string) string {
+	state = strings.ToLower(strings.TrimSpace(state))
+	switch {
+	case strings.HasPrefix(state, "probing"):
+		return "probing"
+	case state == "yes" || state == "active" || state == "enabled":
+		return "enabled"
+	case strings.HasPrefix(state, "no") || strings.HasPrefix(state, "inactive") || strings.HasPrefix(state, "disabled"):
+		return "disabled"
+	}
+	return "unknown"
+}
+
+type droutingCollector struct {
+	gateways, carriers []drEntry
+}
+
+// Describe implements prometheus.Collector.
+func (c *droutingCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range droutingMetrics {
+		ch <- m.Desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *droutingCollector) Collect(ch chan<- prometheus.Metric) {
+	collectDrouting(ch, c.gateways, droutingMetrics["gateway_state"], droutingMetrics["gateways"], true)
+	collectDrouting(ch, c.carriers, droutingMetrics["carrier_state"], droutingMetrics["carriers"], false)
+}
+
+// collectDrouting sends the state of every one of entries, and the number of
+// entries per partition and state.
+func collectDrouting(ch chan<- prometheus.Metric, entries []drEntry, state, count metric, address bool) {
+	var partitions []string
+	counts := make(map[string]map[string]int)
+	for _, e := range entries {
+		if counts[e.partition] == nil {
+			counts[e.partition] = make(map[string]int)
+			partitions = append(partitions, e.partition)
+		}
+		counts[e.partition][e.state]++
+
+		labels := []string{e.partition, e.id}
+		if address {
+			labels = append(labels, e.address)
+		}
+		for _, s := range drStates {
+			var v float64
+			if e.state == s {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				state.Desc,
+				state.ValueType,
+				v,
+				append(labels, s)...,
+			)
+		}
+	}
+	for _, partition := range partitions {
+		for _, s := range drStates {
+			ch <- prometheus.MustNewConstMetric(
+				count.Desc,
+				count.ValueType,
+				float64(counts[partition][s]),
+				partition, s,
+			)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package processors
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+)
+
+func TestParseDrouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		partition string
+		list      string
+		expected  []drEntry
+	}{
+		{
+			name: "2.x gateways",
+			response: "200 OK\n" +
+				"ID:: gw1 IP=10.0.0.1:5060 State=Active\n" +
+				"ID:: gw2 IP=10.0.0.2:5060 State=Inactive\n" +
+				"ID:: gw3 IP=10.0.0.3:5060 State=Probing\n" +
+				"ID:: gw4 IP=10.0.0.4:5060 State=Restarting\n" +
+				"ID:: gw5 IP=10.0.0.5:5060\n\n",
+			list: "Gateways",
+			expected: []drEntry{
+				{"default", "gw1", "10.0.0.1:5060", "enabled"},
+				{"default", "gw2", "10.0.0.2:5060", "disabled"},
+				{"default", "gw3", "10.0.0.3:5060", "probing"},
+				{"default", "gw4", "10.0.0.4:5060", "unknown"},
+				{"default", "gw5", "10.0.0.5:5060", "unknown"},
+			},
+		},
+		{
+			name: "2.x gateways with partitions",
+			response: "200 OK\n" +
+				"Partition:: pstn\n" +
+				"\tID:: gw1 IP=10.0.0.1:5060 State=Active\n" +
+				"\tID:: gw2 IP=10.0.0.2:5060 State=Inactive\n" +
+				"Partition:: internal\n" +
+				"\tID:: gw1 IP=10.0.1.1:5060 State=Active\n\n",
+			list: "Gateways",
+			expected: []drEntry{
+				{"pstn", "gw1", "10.0.0.1:5060", "enabled"},
+				{"pstn", "gw2", "10.0.0.2:5060", "disabled"},
+				{"internal", "gw1", "10.0.1.1:5060", "enabled"},
+			},
+		},
+		{
+			name: "2.x carriers",
+			response: "200 OK\n" +
+				"ID:: carrier1 Enabled=yes\n" +
+				"ID:: carrier2 Enabled=no\n\n",
+			partition: "pstn",
+			list:      "Carriers",
+			expected: []drEntry{
+				{"pstn", "carrier1", "", "enabled"},
+				{"pstn", "carrier2", "", "disabled"},
+			},
+		},
+		{
+			name: "3.x gateways",
+			response: `{"jsonrpc":"2.0","result":{"Gateways":[` +
+				`{"ID":"gw1","IP Address":"10.0.0.1:5060","State":"Active"},` +
+				`{"ID":"gw2","IP Address":"10.0.0.2:5060","State":"Inactive"},` +
+				`{"ID":"gw3","IP Address":"10.0.0.3:5060","State":"Probing"},` +
+				`{"ID":"gw4","IP Address":"10.0.0.4:5060","State":"Restarting"}` +
+				`]},"id":1}`,
+			partition: "pstn",
+			list:      "Gateways",
+			expected: []drEntry{
+				{"pstn", "gw1", "10.0.0.1:5060", "enabled"},
+				{"pstn", "gw2", "10.0.0.2:5060", "disabled"},
+				{"pstn", "gw3", "10.0.0.3:5060", "probing"},
+				{"pstn", "gw4", "10.0.0.4:5060", "unknown"},
+			},
+		},
+		{
+			name: "3.x carriers",
+			response: `{"jsonrpc":"2.0","result":{"Carriers":[` +
+				`{"ID":"carrier1","Enabled":"yes"},` +
+				`{"ID":"carrier2","Enabled":"no (disabled)"}` +
+				`]},"id":1}`,
+			list: "Carriers",
+			expected: []drEntry{
+				{"default", "carrier1", "", "enabled"},
+				{"default", "carrier2", "", "disabled"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := parseDrouting(recordedReply(t, tt.response, "dr_gw_status"), tt.partition, tt.list)
+			if !reflect.DeepEqual(entries, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, entries)
+			}
+		})
+	}
+}
+
+func TestDroutingPartitions(t *testing.T) {
+	c := &fakeCaller{
+		replies: map[string]interface{}{
+			"dr_gw_status":      map[string]interface{}{"Gateways": []interface{}{map[string]interface{}{"ID": "gw1", "State": "Active"}}},
+			"dr_carrier_status": map[string]interface{}{"Carriers": []interface{}{}},
+		},
+		// OpenSIPS 2.x only accepts the partition with a trailing colon.
+		errs: map[string]error{
+			"dr_gw_status pstn":          &opensips.ReplyError{Code: 400, Message: "Bad parameter"},
+			"dr_carrier_status pstn":     &opensips.ReplyError{Code: 400, Message: "Bad parameter"},
+			"dr_gw_status internal":      &opensips.ReplyError{Code: 400, Message: "Bad parameter"},
+			"dr_carrier_status internal": &opensips.ReplyError{Code: 400, Message: "Bad parameter"},
+		},
+	}
+	p := CommandProcessors["drouting"](CommandConfig{DroutingPartitions: []string{"pstn", "internal"}})
+	for i := 0; i < 2; i++ {
+		collector, err := p.Collector(context.Background(), c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gateways := collector.(*droutingCollector).gateways
+		if len(gateways) != 2 || gateways[0].partition != "pstn" || gateways[1].partition != "internal" {
+			t.Errorf("expected gw1 in pstn and internal, got %+v", gateways)
+		}
+	}
+	// The variant with the trailing colon is remembered after the first
+	// scrape.
+	expected := []string{
+		"dr_gw_status pstn", "dr_gw_status pstn:", "dr_carrier_status pstn", "dr_carrier_status pstn:",
+		"dr_gw_status internal", "dr_gw_status internal:", "dr_carrier_status internal", "dr_carrier_status internal:",
+		"dr_gw_status pstn:", "dr_carrier_status pstn:", "dr_gw_status internal:", "dr_carrier_status internal:",
+	}
+	if strings.Join(c.calls, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected calls %q, got %q", expected, c.calls)
+	}
+}
@@ -0,0 +1,126 @@
+package processors
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fallbackProcessor exports the statistics none of the other processors
+// export, e.g. the ones of the acc and dispatcher modules or of custom groups
+// of the statistics module, as opensips_<module>_<name>. As it isn't known
+// whether these statistics are counters or gauges, they are untyped.
+type fallbackProcessor struct {
+	statistics opensips.Statistics
+}
+
+type fallbackMetric struct {
+	metric    metric
+	statistic opensips.Statistic
+	// labelled is set when several statistics end up with the same metric
+	// name, which are then told apart by their module and name.
+	labelled bool
+}
+
+var invalidMetricNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// NewFallbackProcessor is used to export the statistics that aren't exported
+// by any of the OpensipsProcessors.
+func NewFallbackProcessor(s opensips.Statistics) prometheus.Collector {
+	return &fallbackProcessor{
+		statistics: s,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (p fallbackProcessor) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range p.fallbackMetrics() {
+		ch <- m.metric.Desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (p fallbackProcessor) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range p.fallbackMetrics() {
+		if m.labelled {
+			ch <- prometheus.MustNewConstMetric(
+				m.metric.Desc,
+				m.metric.ValueType,
+				m.statistic.Value,
+				m.statistic.Module, m.statistic.Name,
+			)
+		} else {
+			ch <- prometheus.MustNewConstMetric(
+				m.metric.Desc,
+				m.metric.ValueType,
+				m.statistic.Value,
+			)
+		}
+	}
+}
+
+func (p fallbackProcessor) fallbackMetrics() []fallbackMetric {
+	// Group the unknown statistics by the metric name they get.
+	var byName = map[string][]opensips.Statistic{}
+	var names []string
+	for _, s := range p.statistics {
+		if known, ok := knownStatistics[s.Module]; ok && known(s.Name) {
+			continue
+		}
+		name := fallbackMetricName(s)
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], s)
+	}
+
+	var metrics []fallbackMetric
+	for _, name := range names {
+		stats := byName[name]
+		if len(stats) == 1 {
+			s := stats[0]
+			metrics = append(metrics, fallbackMetric{
+				metric:    fallbackMetricFor(name, "Statistic "+s.Module+":"+s.Name+" of OpenSIPS.", nil),
+				statistic: s,
+			})
+			continue
+		}
+		m := fallbackMetricFor(name, "Statistics of OpenSIPS which are exported under the same name.", []string{"module", "statistic"})
+		for _, s := range stats {
+			metrics = append(metrics, fallbackMetric{
+				metric:    m,
+				statistic: s,
+				labelled:  true,
+			})
+		}
+	}
+	return metrics
+}
+
+func fallbackMetricFor(name string, help string, variableLabels []string) metric {
+	return metric{
+		prometheus.NewDesc(name, help, variableLabels, nil),
+		prometheus.UntypedValue,
+	}
+}
+
+// fallbackMetricName returns opensips_<module>_<name>, leaving out the parts
+// that are empty.
+func fallbackMetricName(s opensips.Statistic) string {
+	parts := []string{namespace}
+	for _, part := range []string{s.Module, s.Name} {
+		if part = sanitizeMetricName(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// sanitizeMetricName replaces the characters that aren't allowed in metric
+// names with underscores, e.g. "load-all" becomes "load_all".
+func sanitizeMetricName(name string) string {
+	name = invalidMetricNameChars.ReplaceAllString(name, "_")
+	return strings.Trim(name, "_")
+}
@@ -0,0 +1,82 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+)
+
+func TestFallbackProcessor(t *testing.T) {
+	tests := []struct {
+		name       string
+		statistics []opensips.Statistic
+		expected   []string
+	}{
+		{
+			name: "module and name",
+			statistics: []opensips.Statistic{
+				{Module: "acc", Name: "acc_records", Value: 3},
+			},
+			expected: []string{"opensips_acc_acc_records 3"},
+		},
+		{
+			name: "invalid characters",
+			statistics: []opensips.Statistic{
+				{Module: "custom", Name: "load-all", Value: 4},
+				{Module: "my.module", Name: "calls (active)", Value: 5},
+			},
+			expected: []string{
+				"opensips_custom_load_all 4",
+				"opensips_my_module_calls_active 5",
+			},
+		},
+		{
+			name: "empty parts",
+			statistics: []opensips.Statistic{
+				{Module: "", Name: "orphan", Value: 1},
+				{Module: "custom", Name: "", Value: 2},
+				{Module: "other", Name: "--", Value: 3},
+			},
+			expected: []string{
+				"opensips_custom 2",
+				"opensips_orphan 1",
+				"opensips_other 3",
+			},
+		},
+		{
+			name: "same name",
+			statistics: []opensips.Statistic{
+				{Module: "custom", Name: "calls_active", Value: 1},
+				{Module: "custom_calls", Name: "active", Value: 2},
+				{Module: "custom", Name: "calls-active", Value: 3},
+			},
+			expected: []string{
+				`opensips_custom_calls_active{module="custom",statistic="calls-active"} 3`,
+				`opensips_custom_calls_active{module="custom",statistic="calls_active"} 1`,
+				`opensips_custom_calls_active{module="custom_calls",statistic="active"} 2`,
+			},
+		},
+		{
+			name: "exported by other processors",
+			statistics: []opensips.Statistic{
+				{Module: "core", Name: "rcv_requests", Value: 42},
+				{Module: "shmem", Name: "total_size", Value: 1024},
+				{Module: "core", Name: "new_statistic", Value: 7},
+			},
+			expected: []string{"opensips_core_new_statistic 7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statistics := make(opensips.Statistics)
+			for _, s := range tt.statistics {
+				statistics.Add(s)
+			}
+			metrics := gatheredMetrics(t, NewFallbackProcessor(statistics))
+			if !reflect.DeepEqual(metrics, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, metrics)
+			}
+		})
+	}
+}
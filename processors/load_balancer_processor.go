@@ -0,0 +1,182 @@
+package processors
+
+import (
+	"context"
+	"strings"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// loadBalancerProcessor exports the load and capacity of the resources of the
+// destinations of the load_balancer module, and whether they're enabled, read
+// with the lb_list management function.
+// doc: https://opensips.org/html/docs/modules/3.1.x/load_balancer.html
+// doc: https://opensips.org/html/docs/modules/2.4.x/load_balancer.html
+type loadBalancerProcessor struct{}
+
+// lbDestination is a destination in the reply to lb_list.
+type lbDestination struct {
+	id, group, uri, status string
+	resources              []lbResource
+}
+
+type lbResource struct {
+	name    string
+	load    float64
+	max     float64
+	hasLoad bool
+	hasMax  bool
+}
+
+// lbStatuses are the statuses of a destination: enabled, disabled by probing
+// (and enabled again when it answers) or disabled with lb_status.
+var lbStatuses = []string{"enabled", "auto_disabled", "disabled"}
+
+var loadBalancerLabelNames = []string{"id", "group", "uri"}
+
+var loadBalancerMetrics = map[string]metric{
+	"status":   newMetric("load_balancer", "destination_status", "Whether the status of the destination is the one in the status label.", []string{"id", "group", "uri", "status"}, prometheus.GaugeValue),
+	"load":     newMetric("load_balancer", "load", "Current load of the resource of the destination.", []string{"id", "group", "uri", "resource"}, prometheus.GaugeValue),
+	"max_load": newMetric("load_balancer", "max_load", "Maximum load (capacity) of the resource of the destination.", []string{"id", "group", "uri", "resource"}, prometheus.GaugeValue),
+}
+
+func init() {
+	CommandProcessors["load_balancer"] = func(CommandConfig) CommandProcessor {
+		return loadBalancerProcessor{}
+	}
+}
+
+// Collector implements CommandProcessor.
+func (p loadBalancerProcessor) Collector(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error) {
+	reply, err := c.Call(ctx, "lb_list")
+	if err != nil {
+		return nil, err
+	}
+	return &loadBalancerCollector{destinations: parseLoadBalancer(reply)}, nil
+}
+
+// parseLoadBalancer reads the destinations from the reply to lb_list.
+func parseLoadBalancer(reply interface{}) []lbDestination {
+	var destinations []lbDestination
+	seen := make(map[string]bool)
+	add := func(d lbDestination) {
+		if d.id == "" || seen[d.id] {
+			return
+		}
+		seen[d.id] = true
+		destinations = append(destinations, d)
+	}
+
+	if r, ok := reply.(map[string]interface{}); ok {
+		for _, dst := range replyList(replyField(r, "Destinations")) {
+			m := replyMap(dst)
+			d := lbDestination{
+				id:     replyString(replyField(m, "id")),
+				group:  replyString(replyField(m, "group")),
+				uri:    replyString(replyField(m, "uri")),
+				status: lbStatus(replyString(replyField(m, "enabled")), replyString(replyField(m, "auto-re"))),
+			}
+			for _, rsc := range replyList(replyField(m, "Resources")) {
+				rm := replyMap(rsc)
+				r := lbResource{name: replyString(replyField(rm, "name"))}
+				r.load, r.hasLoad = replyFloat(replyField(rm, "load"))
+				r.max, r.hasMax = replyFloat(replyField(rm, "max"))
+				d.resources = append(d.resources, r)
+			}
+			add(d)
+		}
+		return destinations
+	}
+
+	// The text format lists every destination as a node with the URI as
+	// value and its resources as children, e.g.
+	//
+	//	Destination:: sip:127.0.0.1:5100 id=1 group=1 enabled=yes auto-re=on
+	//		Resource:: pstn max=3 load=0
+	field := func(node map[string]interface{}, name string) string {
+		v, _ := textNodeField(node, name)
+		return replyString(v)
+	}
+	for _, n := range textNodes(reply, "Destination") {
+		d := lbDestination{
+			id:     field(n, "id"),
+			group:  field(n, "group"),
+			uri:    replyString(n[opensips.TextNodeValue]),
+			status: lbStatus(field(n, "enabled"), field(n, "auto-re")),
+		}
+		for _, rn := range textNodes(n[opensips.TextNodeChildren], "Resource") {
+			r := lbResource{name: replyString(rn[opensips.TextNodeValue])}
+			r.load, r.hasLoad = replyFloat(field(rn, "load"))
+			r.max, r.hasMax = replyFloat(field(rn, "max"))
+			d.resources = append(d.resources, r)
+		}
+		add(d)
+	}
+	return destinations
+}
+
+// lbStatus returns the status label for the enabled and auto-re fields of a
+// destination. A disabled destination with auto-re on was disabled by
+// probing, with auto-re off it was disabled with lb_status.
+func lbStatus(enabled, autoReenable string) string {
+	if !strings.EqualFold(strings.TrimSpace(enabled), "no") {
+		return "enabled"
+	}
+	if strings.EqualFold(strings.TrimSpace(autoReenable), "on") {
+		return "auto_disabled"
+	}
+	return "disabled"
+}
+
+type loadBalancerCollector struct {
+	destinations []lbDestination
+}
+
+// Describe implements prometheus.Collector.
+func (c *loadBalancerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range loadBalancerMetrics {
+		ch <- m.Desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *loadBalancerCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, d := range c.destinations {
+		for _, status := range lbStatuses {
+			var v float64
+			if d.status == status {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				loadBalancerMetrics["status"].Desc,
+				loadBalancerMetrics["status"].ValueType,
+				v,
+				d.id, d.group, d.uri, status,
+			)
+		}
+		seen := make(map[string]bool)
+		for _, r := range d.resources {
+			if seen[r.name] {
+				continue
+			}
+			seen[r.name] = true
+			if r.hasLoad {
+				ch <- prometheus.MustNewConstMetric(
+					loadBalancerMetrics["load"].Desc,
+					loadBalancerMetrics["load"].ValueType,
+					r.load,
+					d.id, d.group, d.uri, r.name,
+				)
+			}
+			if r.hasMax {
+				ch <- prometheus.MustNewConstMetric(
+					loadBalancerMetrics["max_load"].Desc,
+					loadBalancerMetrics["max_load"].ValueType,
+					r.max,
+					d.id, d.group, d.uri, r.name,
+				)
+			}
+		}
+	}
+}
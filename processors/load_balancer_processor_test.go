@@ -0,0 +1,76 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLoadBalancer(t *testing.T) {
+	expected := []lbDestination{
+		{id: "1", group: "1", uri: "sip:10.0.0.1:5060", status: "enabled", resources: []lbResource{
+			{name: "pstn", load: 3, max: 30, hasLoad: true, hasMax: true},
+			{name: "transc", load: 0, max: 10, hasLoad: true, hasMax: true},
+		}},
+		{id: "2", group: "1", uri: "sip:10.0.0.2:5060", status: "auto_disabled", resources: []lbResource{
+			{name: "pstn", load: 0, max: 30, hasLoad: true, hasMax: true},
+		}},
+		{id: "3", group: "2", uri: "sip:10.0.0.3:5060", status: "disabled", resources: []lbResource{
+			{name: "pstn", load: 0, max: 20, hasLoad: true, hasMax: true},
+		}},
+	}
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{
+			name: "2.x",
+			response: "200 OK\n" +
+				"Destination:: sip:10.0.0.1:5060 id=1 group=1 enabled=yes auto-re=on\n" +
+				"\tResource:: pstn max=30 load=3\n" +
+				"\tResource:: transc max=10 load=0\n" +
+				"Destination:: sip:10.0.0.2:5060 id=2 group=1 enabled=no auto-re=on\n" +
+				"\tResource:: pstn max=30 load=0\n" +
+				"Destination:: sip:10.0.0.3:5060 id=3 group=2 enabled=no auto-re=off\n" +
+				"\tResource:: pstn max=20 load=0\n\n",
+		},
+		{
+			name: "3.x",
+			response: `{"jsonrpc":"2.0","result":{"Destinations":[` +
+				`{"id":1,"group":1,"uri":"sip:10.0.0.1:5060","enabled":"yes","auto-re":"on","Resources":[` +
+				`{"name":"pstn","load":3,"max":30},{"name":"transc","load":0,"max":10}]},` +
+				`{"id":2,"group":1,"uri":"sip:10.0.0.2:5060","enabled":"no","auto-re":"on","Resources":[` +
+				`{"name":"pstn","load":0,"max":30}]},` +
+				`{"id":3,"group":2,"uri":"sip:10.0.0.3:5060","enabled":"no","auto-re":"off","Resources":[` +
+				`{"name":"pstn","load":0,"max":20}]}` +
+				`]},"id":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destinations := parseLoadBalancer(recordedReply(t, tt.response, "lb_list"))
+			if !reflect.DeepEqual(destinations, expected) {
+				t.Errorf("expected %+v, got %+v", expected, destinations)
+			}
+		})
+	}
+}
+
+func TestLbStatus(t *testing.T) {
+	tests := []struct {
+		enabled, autoReenable string
+		expected              string
+	}{
+		{"yes", "on", "enabled"},
+		{"yes", "off", "enabled"},
+		{"no", "on", "auto_disabled"},
+		{"No", "On", "auto_disabled"},
+		{"no", "off", "disabled"},
+		{"no", "", "disabled"},
+		{"", "", "enabled"},
+	}
+	for _, tt := range tests {
+		if status := lbStatus(tt.enabled, tt.autoReenable); status != tt.expected {
+			t.Errorf("lbStatus(%q, %q): expected %q, got %q", tt.enabled, tt.autoReenable, tt.expected, status)
+		}
+	}
+}
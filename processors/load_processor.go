@@ -12,7 +12,7 @@ import (
 // loadProcessor describes busy children
 // doc: http://www.opensips.org/Documentation/Interface-CoreStatistics-1-11#toc14
 type loadProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 type loadMetric struct {
@@ -25,13 +25,15 @@ type loadMetric struct {
 
 func init() {
 	OpensipsProcessors["load:"] = loadProcessorFunc
-	OpensipsProcessors["tcp-load"] = loadProcessorFunc
-	OpensipsProcessors["load"] = loadProcessorFunc
-	OpensipsProcessors["load1m"] = loadProcessorFunc
-	OpensipsProcessors["load10m"] = loadProcessorFunc
-	OpensipsProcessors["load-all"] = loadProcessorFunc
-	OpensipsProcessors["load1m-all"] = loadProcessorFunc
-	OpensipsProcessors["load10m-all"] = loadProcessorFunc
+	knownStatistics["load"] = func(name string) bool {
+		switch name {
+		case "tcp-load", "load", "load1m", "load10m", "load-all", "load1m-all", "load10m-all", "processes_number":
+			return true
+		}
+		// See loadMetrics for the formats of the per interface and per
+		// process statistics.
+		return strings.Contains(name, "udp:") || strings.Contains(name, "tcp:") || strings.Contains(name, "proc")
+	}
 }
 
 // Describe implements prometheus.Collector.
@@ -48,27 +50,27 @@ func (p loadProcessor) Collect(ch chan<- prometheus.Metric) {
 			ch <- prometheus.MustNewConstMetric(
 				u.metric.Desc,
 				u.metric.ValueType,
-				p.statistics[key].Value,
+				p.statistics.Value("load", key),
 				u.ip, u.port, u.protocol,
 			)
 		} else if u.process != "" {
 			ch <- prometheus.MustNewConstMetric(
 				u.metric.Desc,
 				u.metric.ValueType,
-				p.statistics[key].Value,
+				p.statistics.Value("load", key),
 				u.process,
 			)
 		} else {
 			ch <- prometheus.MustNewConstMetric(
 				u.metric.Desc,
 				u.metric.ValueType,
-				p.statistics[key].Value,
+				p.statistics.Value("load", key),
 			)
 		}
 	}
 }
 
-func loadProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func loadProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &loadProcessor{
 		statistics: s,
 	}
@@ -77,12 +79,7 @@ func loadProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
 func (p loadProcessor) loadMetrics() map[string]loadMetric {
 	var metrics = map[string]loadMetric{}
 
-	var stats []opensips.Statistic
-	for _, s := range p.statistics {
-		if s.Module == "load" {
-			stats = append(stats, s)
-		}
-	}
+	stats := p.statistics.Module("load")
 
 	for _, s := range stats {
 
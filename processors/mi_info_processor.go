@@ -0,0 +1,41 @@
+package processors
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type miInfoProcessor struct {
+	infoMetric metric
+	protocol   string
+	format     string
+	version    string
+}
+
+// Describe implements prometheus.Collector.
+func (p miInfoProcessor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.infoMetric.Desc
+}
+
+// Collect implements prometheus.Collector.
+func (p miInfoProcessor) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		p.infoMetric.Desc,
+		p.infoMetric.ValueType,
+		1,
+		p.protocol,
+		p.format,
+		p.version,
+	)
+}
+
+// NewMIInfoProcessor is used to export the protocol and format of the
+// Management Interface and the version of OpenSIPS detected with -protocol
+// auto, as labels of a metric which is always 1.
+func NewMIInfoProcessor(protocol, format, version string) prometheus.Collector {
+	return &miInfoProcessor{
+		infoMetric: newMetric("mi", "info", "Protocol and format of the Management Interface and version of OpenSIPS, as detected with -protocol auto.", []string{"protocol", "format", "version"}, prometheus.GaugeValue),
+		protocol:   protocol,
+		format:     format,
+		version:    version,
+	}
+}
@@ -9,7 +9,7 @@ import (
 // doc: http://www.opensips.org/Documentation/Interface-CoreStatistics-1-11#toc17
 // src: https://github.com/OpenSIPS/opensips/blob/b917c70ba8d5797dc6364aaf702c3415539be52a/core_stats.c#L95
 type netProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 var netLabelNames = []string{"protocol"}
@@ -20,10 +20,8 @@ var netMetrics = map[string]metric{
 }
 
 func init() {
-	for metric := range netMetrics {
-		OpensipsProcessors[metric] = netProcessorFunc
-	}
 	OpensipsProcessors["net:"] = netProcessorFunc
+	knownStatistics["net"] = knownMetrics(netMetrics)
 }
 
 // Describe implements prometheus.Collector.
@@ -35,36 +33,34 @@ func (p netProcessor) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (p netProcessor) Collect(ch chan<- prometheus.Metric) {
-	for _, s := range p.statistics {
-		if s.Module == "net" {
-			switch s.Name {
-			case "waiting_udp":
-				ch <- prometheus.MustNewConstMetric(
-					netMetrics["waiting_udp"].Desc,
-					netMetrics["waiting_udp"].ValueType,
-					s.Value,
-					"udp",
-				)
-			case "waiting_tcp":
-				ch <- prometheus.MustNewConstMetric(
-					netMetrics["waiting_tcp"].Desc,
-					netMetrics["waiting_tcp"].ValueType,
-					s.Value,
-					"tcp",
-				)
-			case "waiting_tls":
-				ch <- prometheus.MustNewConstMetric(
-					netMetrics["waiting_tls"].Desc,
-					netMetrics["waiting_tls"].ValueType,
-					s.Value,
-					"tls",
-				)
-			}
+	for _, s := range p.statistics.Module("net") {
+		switch s.Name {
+		case "waiting_udp":
+			ch <- prometheus.MustNewConstMetric(
+				netMetrics["waiting_udp"].Desc,
+				netMetrics["waiting_udp"].ValueType,
+				s.Value,
+				"udp",
+			)
+		case "waiting_tcp":
+			ch <- prometheus.MustNewConstMetric(
+				netMetrics["waiting_tcp"].Desc,
+				netMetrics["waiting_tcp"].ValueType,
+				s.Value,
+				"tcp",
+			)
+		case "waiting_tls":
+			ch <- prometheus.MustNewConstMetric(
+				netMetrics["waiting_tls"].Desc,
+				netMetrics["waiting_tls"].ValueType,
+				s.Value,
+				"tls",
+			)
 		}
 	}
 }
 
-func netProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func netProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &netProcessor{
 		statistics: s,
 	}
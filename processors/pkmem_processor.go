@@ -11,7 +11,7 @@ import (
 // doc: http://www.opensips.org/Documentation/Interface-CoreStatistics-1-11#toc28
 // src: https://github.com/OpenSIPS/opensips/blob/b917c70ba8d5797dc6364aaf702c3415539be52a/core_stats.c#L165
 type pkmemProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 type pkmemMetric struct {
@@ -21,12 +21,14 @@ type pkmemMetric struct {
 
 func init() {
 	OpensipsProcessors["pkmem:"] = pkmemProcessorFunc
-	OpensipsProcessors["total_size"] = pkmemProcessorFunc
-	OpensipsProcessors["used_size"] = pkmemProcessorFunc
-	OpensipsProcessors["real_used_size"] = pkmemProcessorFunc
-	OpensipsProcessors["max_used_size"] = pkmemProcessorFunc
-	OpensipsProcessors["free_size"] = pkmemProcessorFunc
-	OpensipsProcessors["fragments"] = pkmemProcessorFunc
+	knownStatistics["pkmem"] = func(name string) bool {
+		split := strings.Index(name, "-")
+		switch name[split+1:] {
+		case "total_size", "used_size", "real_used_size", "max_used_size", "free_size", "fragments":
+			return split != -1
+		}
+		return false
+	}
 }
 
 // Describe implements prometheus.Collector.
@@ -42,13 +44,13 @@ func (p pkmemProcessor) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(
 			u.metric.Desc,
 			u.metric.ValueType,
-			p.statistics[key].Value,
+			p.statistics.Value("pkmem", key),
 			u.pid,
 		)
 	}
 }
 
-func pkmemProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func pkmemProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &pkmemProcessor{
 		statistics: s,
 	}
@@ -58,12 +60,7 @@ func (p pkmemProcessor) pkmemMetrics() map[string]pkmemMetric {
 	var metrics = map[string]pkmemMetric{}
 
 	// Get all pkmem statistics
-	var stats []opensips.Statistic
-	for _, s := range p.statistics {
-		if s.Module == "pkmem" {
-			stats = append(stats, s)
-		}
-	}
+	stats := p.statistics.Module("pkmem")
 
 	for _, s := range stats {
 		split := strings.Index(s.Name, "-")
@@ -0,0 +1,44 @@
+package processors
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type pollProcessor struct {
+	ageMetric     metric
+	age           float64
+	successMetric metric
+	success       float64
+}
+
+// Describe implements prometheus.Collector.
+func (p pollProcessor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.ageMetric.Desc
+	ch <- p.successMetric.Desc
+}
+
+// Collect implements prometheus.Collector.
+func (p pollProcessor) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		p.ageMetric.Desc,
+		p.ageMetric.ValueType,
+		p.age,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		p.successMetric.Desc,
+		p.successMetric.ValueType,
+		p.success,
+	)
+}
+
+// NewPollProcessor is used to export meta metrics about the statistics polled
+// in the background: the age in seconds of the snapshot the metrics are
+// created from, and whether the last poll could read statistics.
+func NewPollProcessor(age float64, success float64) prometheus.Collector {
+	return &pollProcessor{
+		ageMetric:     newMetric("poll", "snapshot_age_seconds", "Seconds since the statistics the metrics are created from were polled from the Management Interface.", []string{}, prometheus.GaugeValue),
+		age:           age,
+		successMetric: newMetric("poll", "last_success", "Whether the last poll could read statistics from the Management Interface.", []string{}, prometheus.GaugeValue),
+		success:       success,
+	}
+}
@@ -1,6 +1,8 @@
 package processors
 
 import (
+	"context"
+
 	"github.com/VoIPGRID/opensips_exporter/opensips"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -10,13 +12,99 @@ type metric struct {
 	ValueType prometheus.ValueType
 }
 
-type processor func(map[string]opensips.Statistic) prometheus.Collector
+// Processor creates a collector exporting the statistics of a subsystem.
+type Processor func(opensips.Statistics) prometheus.Collector
 
 // OpensipsProcessors is a map of processors for each subsystem
-var OpensipsProcessors = make(map[string]processor)
+var OpensipsProcessors = make(map[string]Processor)
+
+// CommandProcessor creates collectors exporting the replies of management
+// functions (e.g. version or ds_list). A CommandProcessor is created for every
+// OpenSIPS, so it can keep state between scrapes.
+type CommandProcessor interface {
+	// Collector calls the management functions through c, and returns a
+	// collector for the replies. The statistics read in the same scrape are
+	// passed as well.
+	Collector(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error)
+}
+
+// CommandProcessorFunc is a CommandProcessor that doesn't keep state.
+type CommandProcessorFunc func(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error)
+
+// Collector implements CommandProcessor.
+func (f CommandProcessorFunc) Collector(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error) {
+	return f(ctx, c, statistics)
+}
+
+// CommandConfig holds the settings of the command processors of an OpenSIPS.
+type CommandConfig struct {
+	// DroutingPartitions are the partitions of the drouting module to read
+	// the gateways and carriers of. When empty, they're read without a
+	// partition, which only works when the drouting module doesn't use
+	// partitions.
+	DroutingPartitions []string
+}
+
+// CommandProcessors is a map of the constructors of the command processors for
+// each collect group. Unlike the groups of OpensipsProcessors, these groups
+// aren't statistics groups and have no trailing colon (e.g. "build_info").
+var CommandProcessors = make(map[string]func(CommandConfig) CommandProcessor)
+
+// knownStatistics holds for each module a func reporting whether a statistic
+// of that module is exported by its processor.
+var knownStatistics = make(map[string]func(name string) bool)
+
+// knownMetrics returns a func reporting whether a statistic is in metrics.
+func knownMetrics(metrics map[string]metric) func(name string) bool {
+	return func(name string) bool {
+		_, ok := metrics[name]
+		return ok
+	}
+}
 
 const namespace = "opensips"
 
+// labelNames holds the names of the labels of the metrics of all processors,
+// including the ones only created while collecting (e.g. pid and process) and
+// the le label of histogram buckets. TestLabelNames checks that it's complete.
+var labelNames = map[string]bool{
+	"address":      true,
+	"command":      true,
+	"compile_time": true,
+	"domain":       true,
+	"event":        true,
+	"flags":        true,
+	"format":       true,
+	"group":        true,
+	"id":           true,
+	"ip":           true,
+	"kind":         true,
+	"le":           true,
+	"module":       true,
+	"node":         true,
+	"partition":    true,
+	"pid":          true,
+	"port":         true,
+	"process":      true,
+	"protocol":     true,
+	"resource":     true,
+	"server":       true,
+	"set":          true,
+	"state":        true,
+	"statistic":    true,
+	"status":       true,
+	"transport":    true,
+	"type":         true,
+	"uri":          true,
+	"version":      true,
+}
+
+// IsLabelName reports whether name is the name of a label of a metric of any
+// processor, so that it can't be used for a label added to all metrics.
+func IsLabelName(name string) bool {
+	return labelNames[name]
+}
+
 func newMetric(subsystem string, name string, help string, variableLabels []string, t prometheus.ValueType) metric {
 	return metric{
 		prometheus.NewDesc(
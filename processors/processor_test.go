@@ -0,0 +1,143 @@
+package processors
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatheredMetrics returns the metrics collected by c in the text exposition
+// format without help and type lines, e.g. `opensips_load_all 4`, sorted.
+// Histograms only get their _count.
+func gatheredMetrics(t *testing.T, c prometheus.Collector) []string {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics []string
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			name := family.GetName()
+			if len(labels) > 0 {
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+			var value float64
+			switch {
+			case m.Histogram != nil:
+				// Only the number of observations, as the durations vary.
+				name = family.GetName() + "_count" + name[len(family.GetName()):]
+				value = float64(m.GetHistogram().GetSampleCount())
+			case m.Counter != nil:
+				value = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				value = m.GetGauge().GetValue()
+			case m.Untyped != nil:
+				value = m.GetUntyped().GetValue()
+			default:
+				t.Fatalf("unexpected type of %s", family.GetName())
+			}
+			metrics = append(metrics, fmt.Sprintf("%s %v", name, value))
+		}
+	}
+	sort.Strings(metrics)
+	return metrics
+}
+
+var variableLabelsRE = regexp.MustCompile(`variableLabels: \[([^\]]*)\]`)
+
+// describedLabelNames returns the names of the variable labels of the metrics
+// described by c.
+func describedLabelNames(t *testing.T, c prometheus.Collector) []string {
+	t.Helper()
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	var names []string
+	for desc := range ch {
+		m := variableLabelsRE.FindStringSubmatch(desc.String())
+		if m == nil {
+			t.Fatalf("no variable labels in %s", desc)
+		}
+		names = append(names, strings.Fields(m[1])...)
+	}
+	return names
+}
+
+func TestLabelNames(t *testing.T) {
+	// The statistics create at least one metric in every processor, including
+	// the ones that only create their metrics while collecting.
+	statistics := make(opensips.Statistics)
+	for _, s := range []opensips.Statistic{
+		{Module: "core", Name: "rcv_requests"},
+		{Module: "core", Name: "fwd_requests"},
+		{Module: "dialog", Name: "active_dialogs"},
+		{Module: "dialog", Name: "create_sent"},
+		{Module: "load", Name: "load"},
+		{Module: "load", Name: "load-proc-1"},
+		{Module: "load", Name: "udp:127.0.0.1:5060-load"},
+		{Module: "net", Name: "waiting_udp"},
+		{Module: "pkmem", Name: "1-total_size"},
+		{Module: "registrar", Name: "accepted_regs"},
+		{Module: "shmem", Name: "total_size"},
+		{Module: "sl", Name: "1xx_replies"},
+		{Module: "tm", Name: "UAS_transactions"},
+		{Module: "tmx", Name: "rpl_received"},
+		{Module: "uri", Name: "positive"},
+		{Module: "usrloc", Name: "location-users"},
+		// Statistics that end up with the same metric name get the labels
+		// of the fallback processor.
+		{Module: "custom", Name: "calls_active"},
+		{Module: "custom_calls", Name: "active"},
+	} {
+		statistics.Add(s)
+	}
+	collectors := map[string]prometheus.Collector{
+		"dispatcher":    &dispatcherCollector{},
+		"drouting":      &droutingCollector{},
+		"load_balancer": &loadBalancerCollector{},
+		"rtpproxy":      &rtpproxyCollector{},
+		"build_info":    buildInfoCollector{},
+		"scrape":        NewScrapeProcessor(Scrape{Stats: NewScrapeStats()}),
+		"mi_info":       NewMIInfoProcessor("mi_datagram", "text", "3.4.0"),
+		"poll":          NewPollProcessor(1, 1),
+		"fallback":      NewFallbackProcessor(statistics),
+	}
+	for group, p := range OpensipsProcessors {
+		collectors[group] = p(statistics)
+	}
+
+	for name, c := range collectors {
+		for _, label := range describedLabelNames(t, c) {
+			if !IsLabelName(label) {
+				t.Errorf("label %q of the %s metrics isn't in labelNames", label, name)
+			}
+		}
+	}
+	for _, label := range []string{"pid", "process", "ip", "port", "domain", "module", "statistic"} {
+		if !IsLabelName(label) {
+			t.Errorf("expected %s to be a label name", label)
+		}
+	}
+	if !IsLabelName("le") {
+		t.Error("expected the le label of the histogram buckets to be a label name")
+	}
+	if IsLabelName("datacenter") {
+		t.Error("expected datacenter not to be a label name")
+	}
+}
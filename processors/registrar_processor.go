@@ -9,7 +9,7 @@ import (
 // doc: http://www.opensips.org/html/docs/modules/1.11.x/registrar.html#idp5702944
 // src: https://github.com/OpenSIPS/opensips/blob/1.11/modules/registrar/reg_mod.c#L202
 type registrarProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 var registrarLabelNames = []string{}
@@ -22,10 +22,8 @@ var registrarMetrics = map[string]metric{
 }
 
 func init() {
-	for metric := range registrarMetrics {
-		OpensipsProcessors[metric] = registrarProcessorFunc
-	}
 	OpensipsProcessors["registrar:"] = registrarProcessorFunc
+	knownStatistics["registrar"] = knownMetrics(registrarMetrics)
 }
 
 // Describe implements prometheus.Collector.
@@ -37,47 +35,45 @@ func (p registrarProcessor) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (p registrarProcessor) Collect(ch chan<- prometheus.Metric) {
-	for _, s := range p.statistics {
-		if s.Module == "registrar" {
-			switch s.Name {
-			case "max_expires":
-				ch <- prometheus.MustNewConstMetric(
-					registrarMetrics["max_expires"].Desc,
-					registrarMetrics["max_expires"].ValueType,
-					s.Value,
-				)
-			case "max_contacts":
-				ch <- prometheus.MustNewConstMetric(
-					registrarMetrics["max_contacts"].Desc,
-					registrarMetrics["max_contacts"].ValueType,
-					s.Value,
-				)
-			case "default_expire":
-				ch <- prometheus.MustNewConstMetric(
-					registrarMetrics["default_expire"].Desc,
-					registrarMetrics["default_expire"].ValueType,
-					s.Value,
-				)
-			case "accepted_regs":
-				ch <- prometheus.MustNewConstMetric(
-					registrarMetrics["accepted_regs"].Desc,
-					registrarMetrics["accepted_regs"].ValueType,
-					s.Value,
-					"accepted",
-				)
-			case "rejected_regs":
-				ch <- prometheus.MustNewConstMetric(
-					registrarMetrics["rejected_regs"].Desc,
-					registrarMetrics["rejected_regs"].ValueType,
-					s.Value,
-					"rejected",
-				)
-			}
+	for _, s := range p.statistics.Module("registrar") {
+		switch s.Name {
+		case "max_expires":
+			ch <- prometheus.MustNewConstMetric(
+				registrarMetrics["max_expires"].Desc,
+				registrarMetrics["max_expires"].ValueType,
+				s.Value,
+			)
+		case "max_contacts":
+			ch <- prometheus.MustNewConstMetric(
+				registrarMetrics["max_contacts"].Desc,
+				registrarMetrics["max_contacts"].ValueType,
+				s.Value,
+			)
+		case "default_expire":
+			ch <- prometheus.MustNewConstMetric(
+				registrarMetrics["default_expire"].Desc,
+				registrarMetrics["default_expire"].ValueType,
+				s.Value,
+			)
+		case "accepted_regs":
+			ch <- prometheus.MustNewConstMetric(
+				registrarMetrics["accepted_regs"].Desc,
+				registrarMetrics["accepted_regs"].ValueType,
+				s.Value,
+				"accepted",
+			)
+		case "rejected_regs":
+			ch <- prometheus.MustNewConstMetric(
+				registrarMetrics["rejected_regs"].Desc,
+				registrarMetrics["rejected_regs"].ValueType,
+				s.Value,
+				"rejected",
+			)
 		}
 	}
 }
 
-func registrarProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func registrarProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &registrarProcessor{
 		statistics: s,
 	}
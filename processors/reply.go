@@ -0,0 +1,128 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+)
+
+// The replies of management functions are trees of maps, slices and scalars
+// (see opensips.Caller). Replies in the JSON-RPC format (OpenSIPS >= 3.0) are
+// maps keyed by field name, replies in the text format (OpenSIPS < 3.0) are
+// lists of nodes with a name, a value, attributes and children (see
+// opensips.TextNodeName). The helpers below read both.
+
+// replyList returns v as a list, or nil when it isn't one.
+func replyList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+// replyMap returns v as a map, or nil when it isn't one.
+func replyMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// replyField returns the first of the fields names (ignoring case) of the
+// object m that is present, or nil when there's none.
+func replyField(m map[string]interface{}, names ...string) interface{} {
+	for _, name := range names {
+		for field, v := range m {
+			if strings.EqualFold(field, name) {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// replyString returns the scalar v as a string, or an empty string when v is
+// nil.
+func replyString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// replyFloat returns the scalar v as a number, if it is one.
+func replyFloat(v interface{}) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(v)), 64)
+	return f, err == nil
+}
+
+// textNodes returns the nodes named name (ignoring case) in nodes, which is the
+// reply in the text format or the children of a node.
+func textNodes(nodes interface{}, name string) []map[string]interface{} {
+	var res []map[string]interface{}
+	for _, n := range replyList(nodes) {
+		node := replyMap(n)
+		if node != nil && strings.EqualFold(replyString(node[opensips.TextNodeName]), name) {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// textNodeField returns the attribute or, when there's no such attribute, the
+// value of the child node of node with the first of names (ignoring case)
+// that is present.
+func textNodeField(node map[string]interface{}, names ...string) (interface{}, bool) {
+	for _, name := range names {
+		for attribute, v := range replyMap(node[opensips.TextNodeAttributes]) {
+			if strings.EqualFold(attribute, name) {
+				return v, true
+			}
+		}
+		if children := textNodes(node[opensips.TextNodeChildren], name); len(children) > 0 {
+			return children[0][opensips.TextNodeValue], true
+		}
+	}
+	return nil, false
+}
+
+// callVariants calls a management function that takes different parameters
+// depending on the version of OpenSIPS (e.g. "full" in the text format and 1 in
+// the JSON-RPC format). The variants are tried in order until OpenSIPS doesn't
+// reject one, and the one that worked is tried first the next time.
+type callVariants struct {
+	method   string
+	variants [][]interface{}
+
+	mu      sync.Mutex
+	current int
+}
+
+// call calls the management function through c.
+func (v *callVariants) call(ctx context.Context, c opensips.Caller) (interface{}, error) {
+	v.mu.Lock()
+	current := v.current
+	v.mu.Unlock()
+
+	var err error
+	for i := range v.variants {
+		variant := (current + i) % len(v.variants)
+		var reply interface{}
+		reply, err = c.Call(ctx, v.method, v.variants[variant]...)
+		var replyErr *opensips.ReplyError
+		if errors.As(err, &replyErr) {
+			continue
+		}
+		if err == nil {
+			v.mu.Lock()
+			v.current = variant
+			v.mu.Unlock()
+		}
+		return reply, err
+	}
+	return nil, err
+}
@@ -0,0 +1,69 @@
+package processors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/internal/mock"
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"golang.org/x/sync/errgroup"
+)
+
+// recordedReply returns the reply to method as Call returns it when
+// OpenSIPS sends response, a reply recorded from mi_datagram in the text
+// format (starting with "200 OK") or the JSON-RPC format.
+func recordedReply(t *testing.T, response, method string, params ...interface{}) interface{} {
+	t.Helper()
+	m, err := mock.New([]byte(response), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	format := opensips.FormatText
+	if strings.HasPrefix(response, "{") {
+		format = opensips.FormatJSON
+	}
+	o, err := opensips.New(m.Socket(), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	var g errgroup.Group
+	g.Go(func() error {
+		return m.Run(1, time.Now().Add(time.Second))
+	})
+	reply, err := o.Call(context.Background(), method, params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// fakeCaller is an opensips.Caller answering every call with the error for
+// the call, else the reply for its method. The calls are recorded, and looked
+// up in errs, as the method followed by the parameters, e.g.
+// "dr_gw_status pstn".
+type fakeCaller struct {
+	replies map[string]interface{}
+	errs    map[string]error
+	calls   []string
+}
+
+func (c *fakeCaller) Call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	call := method
+	for _, p := range params {
+		call += " " + fmt.Sprint(p)
+	}
+	c.calls = append(c.calls, call)
+	if err, ok := c.errs[call]; ok {
+		return nil, err
+	}
+	return c.replies[method], nil
+}
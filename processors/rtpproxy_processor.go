@@ -0,0 +1,181 @@
+package processors
+
+import (
+	"context"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rtpproxyProcessor exports the state, weight and recheck ticks of the nodes
+// of the sets of the rtpproxy module, read with the rtpproxy_show management
+// function.
+// doc: https://opensips.org/html/docs/modules/3.1.x/rtpproxy.html
+// doc: https://opensips.org/html/docs/modules/2.4.x/rtpproxy.html
+type rtpproxyProcessor struct{}
+
+// rtpproxyNode is a node in the reply to rtpproxy_show.
+type rtpproxyNode struct {
+	set, url, state            string
+	weight, recheckTicks       float64
+	hasWeight, hasRecheckTicks bool
+}
+
+// rtpproxyStates are the states of a node, in the format of the state label.
+var rtpproxyStates = []string{"enabled", "disabled"}
+
+var rtpproxyLabelNames = []string{"set", "node"}
+
+var rtpproxyMetrics = map[string]metric{
+	"state":         newMetric("rtpproxy", "node_state", "Whether the state of the node is the one in the state label.", []string{"set", "node", "state"}, prometheus.GaugeValue),
+	"weight":        newMetric("rtpproxy", "node_weight", "Weight of the node.", rtpproxyLabelNames, prometheus.GaugeValue),
+	"recheck_ticks": newMetric("rtpproxy", "node_recheck_ticks", "Ticks at which a disabled node is checked again.", rtpproxyLabelNames, prometheus.GaugeValue),
+	"nodes":         newMetric("rtpproxy", "nodes", "Number of nodes in the set in the state.", []string{"set", "state"}, prometheus.GaugeValue),
+}
+
+func init() {
+	CommandProcessors["rtpproxy"] = func(CommandConfig) CommandProcessor {
+		return rtpproxyProcessor{}
+	}
+}
+
+// Collector implements CommandProcessor.
+func (p rtpproxyProcessor) Collector(ctx context.Context, c opensips.Caller, statistics opensips.Statistics) (prometheus.Collector, error) {
+	reply, err := c.Call(ctx, "rtpproxy_show")
+	if err != nil {
+		return nil, err
+	}
+	return &rtpproxyCollector{nodes: parseRtpproxy(reply)}, nil
+}
+
+// parseRtpproxy reads the nodes from the reply to rtpproxy_show.
+func parseRtpproxy(reply interface{}) []rtpproxyNode {
+	var nodes []rtpproxyNode
+	seen := make(map[[2]string]bool)
+	add := func(n rtpproxyNode) {
+		key := [2]string{n.set, n.url}
+		if n.url == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		nodes = append(nodes, n)
+	}
+
+	// In the JSON-RPC format the sets are a list, possibly in a Sets field,
+	// with the nodes in the Nodes field of every set.
+	sets := replyList(reply)
+	if r, ok := reply.(map[string]interface{}); ok {
+		sets = replyList(replyField(r, "Sets"))
+	}
+	for _, s := range sets {
+		set := replyMap(s)
+		if _, text := set[opensips.TextNodeName]; text {
+			continue
+		}
+		for _, n := range replyList(replyField(set, "Nodes")) {
+			m := replyMap(n)
+			node := rtpproxyNode{
+				set:   replyString(replyField(set, "Set", "Set-id", "id")),
+				url:   replyString(replyField(m, "Node", "url")),
+				state: rtpproxyState(replyField(m, "Disabled")),
+			}
+			node.weight, node.hasWeight = replyFloat(replyField(m, "Weight"))
+			node.recheckTicks, node.hasRecheckTicks = replyFloat(replyField(m, "Recheck-ticks", "Recheck_ticks"))
+			add(node)
+		}
+	}
+
+	// In the text format every set is a node with the nodes as children, e.g.
+	//
+	//	Set:: 0
+	//		node:: udp:127.0.0.1:22222 index=0 disabled=0 weight=1 recheck_ticks=0
+	for _, set := range textNodes(reply, "Set") {
+		for _, n := range textNodes(set[opensips.TextNodeChildren], "node") {
+			node := rtpproxyNode{
+				set: replyString(set[opensips.TextNodeValue]),
+				url: replyString(n[opensips.TextNodeValue]),
+			}
+			disabled, _ := textNodeField(n, "disabled")
+			node.state = rtpproxyState(disabled)
+			if v, ok := textNodeField(n, "weight"); ok {
+				node.weight, node.hasWeight = replyFloat(v)
+			}
+			if v, ok := textNodeField(n, "recheck_ticks", "recheck-ticks"); ok {
+				node.recheckTicks, node.hasRecheckTicks = replyFloat(v)
+			}
+			add(node)
+		}
+	}
+	return nodes
+}
+
+// rtpproxyState returns the state label for the disabled field of a node.
+func rtpproxyState(disabled interface{}) string {
+	if v, ok := replyFloat(disabled); ok && v != 0 {
+		return "disabled"
+	}
+	return "enabled"
+}
+
+type rtpproxyCollector struct {
+	nodes []rtpproxyNode
+}
+
+// Describe implements prometheus.Collector.
+func (c *rtpproxyCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range rtpproxyMetrics {
+		ch <- m.Desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *rtpproxyCollector) Collect(ch chan<- prometheus.Metric) {
+	var sets []string
+	counts := make(map[string]map[string]int)
+	for _, n := range c.nodes {
+		if counts[n.set] == nil {
+			counts[n.set] = make(map[string]int)
+			sets = append(sets, n.set)
+		}
+		counts[n.set][n.state]++
+
+		for _, state := range rtpproxyStates {
+			var v float64
+			if n.state == state {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				rtpproxyMetrics["state"].Desc,
+				rtpproxyMetrics["state"].ValueType,
+				v,
+				n.set, n.url, state,
+			)
+		}
+		if n.hasWeight {
+			ch <- prometheus.MustNewConstMetric(
+				rtpproxyMetrics["weight"].Desc,
+				rtpproxyMetrics["weight"].ValueType,
+				n.weight,
+				n.set, n.url,
+			)
+		}
+		if n.hasRecheckTicks {
+			ch <- prometheus.MustNewConstMetric(
+				rtpproxyMetrics["recheck_ticks"].Desc,
+				rtpproxyMetrics["recheck_ticks"].ValueType,
+				n.recheckTicks,
+				n.set, n.url,
+			)
+		}
+	}
+	for _, set := range sets {
+		for _, state := range rtpproxyStates {
+			ch <- prometheus.MustNewConstMetric(
+				rtpproxyMetrics["nodes"].Desc,
+				rtpproxyMetrics["nodes"].ValueType,
+				float64(counts[set][state]),
+				set, state,
+			)
+		}
+	}
+}
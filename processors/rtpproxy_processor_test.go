@@ -0,0 +1,46 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRtpproxy(t *testing.T) {
+	expected := []rtpproxyNode{
+		{set: "0", url: "udp:127.0.0.1:22222", state: "enabled", weight: 1, hasWeight: true, hasRecheckTicks: true},
+		{set: "0", url: "udp:127.0.0.1:22223", state: "disabled", weight: 2, recheckTicks: 5, hasWeight: true, hasRecheckTicks: true},
+		{set: "1", url: "udp:10.0.0.5:22222", state: "enabled", weight: 1, hasWeight: true, hasRecheckTicks: true},
+	}
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{
+			name: "2.x",
+			response: "200 OK\n" +
+				"Set:: 0\n" +
+				"\tnode:: udp:127.0.0.1:22222 index=0 disabled=0 weight=1 recheck_ticks=0\n" +
+				"\tnode:: udp:127.0.0.1:22223 index=1 disabled=1 weight=2 recheck_ticks=5\n" +
+				"Set:: 1\n" +
+				"\tnode:: udp:10.0.0.5:22222 index=0 disabled=0 weight=1 recheck_ticks=0\n\n",
+		},
+		{
+			name: "3.x",
+			response: `{"jsonrpc":"2.0","result":{"Sets":[` +
+				`{"id":0,"Nodes":[` +
+				`{"Node":"udp:127.0.0.1:22222","Index":0,"Disabled":0,"Weight":1,"Recheck-ticks":0},` +
+				`{"Node":"udp:127.0.0.1:22223","Index":1,"Disabled":1,"Weight":2,"Recheck-ticks":5}]},` +
+				`{"id":1,"Nodes":[` +
+				`{"Node":"udp:10.0.0.5:22222","Index":0,"Disabled":0,"Weight":1,"Recheck-ticks":0}]}` +
+				`]},"id":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := parseRtpproxy(recordedReply(t, tt.response, "rtpproxy_show"))
+			if !reflect.DeepEqual(nodes, expected) {
+				t.Errorf("expected %+v, got %+v", expected, nodes)
+			}
+		})
+	}
+}
@@ -1,17 +1,154 @@
 package processors
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Scrape holds the outcome of reading the statistics of an OpenSIPS.
+type Scrape struct {
+	// Up is 1 when the statistics of any of the groups could be read.
+	Up float64
+	// Groups holds whether the statistics of each requested group (e.g.
+	// "core:") could be read.
+	Groups map[string]float64
+	// Statistics holds the number of statistics read per module.
+	Statistics map[string]int
+	// ParseErrors holds the number of statistics that couldn't be parsed per
+	// module.
+	ParseErrors map[string]int
+	// RegisterErrors is the number of processors that couldn't be registered.
+	RegisterErrors int
+	// Stats holds the meta metrics kept between scrapes, if any.
+	Stats *ScrapeStats
+}
+
+// ScrapeStats holds the meta metrics about scraping an OpenSIPS which are kept
+// between scrapes. It's safe for concurrent use.
+type ScrapeStats struct {
+	roundtrips   *prometheus.HistogramVec
+	scrapes      prometheus.Counter
+	scrapeErrors prometheus.Counter
+	timeouts     prometheus.Counter
+	retries      prometheus.Counter
+	circuitOpen  prometheus.Gauge
+	rejected     prometheus.Counter
+}
+
+// NewScrapeStats creates the ScrapeStats for an OpenSIPS.
+func NewScrapeStats() *ScrapeStats {
+	return &ScrapeStats{
+		roundtrips: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "mi",
+			Name:      "roundtrip_duration_seconds",
+			Help:      "Duration of the calls to the Management Interface.",
+		}, []string{"transport", "command"}),
+		scrapes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "total",
+			Help:      "Number of times the statistics were read from the Management Interface.",
+		}),
+		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrape",
+			Name:      "errors_total",
+			Help:      "Number of times the statistics of one or more groups couldn't be read from the Management Interface.",
+		}),
+		timeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "mi",
+			Name:      "timeouts_total",
+			Help:      "Number of calls to the Management Interface that timed out.",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "mi",
+			Name:      "retries_total",
+			Help:      "Number of calls to the Management Interface that were retried.",
+		}),
+		circuitOpen: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "mi",
+			Name:      "circuit_open",
+			Help:      "Whether the Management Interface isn't called because of repeated failures.",
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "mi",
+			Name:      "circuit_rejected_total",
+			Help:      "Number of scrapes for which the Management Interface wasn't called because of repeated failures.",
+		}),
+	}
+}
+
+// ObserveRoundtrip records the duration of a call of the management function
+// command through transport (e.g. "mi_datagram").
+func (s *ScrapeStats) ObserveRoundtrip(transport, command string, d time.Duration) {
+	s.roundtrips.WithLabelValues(transport, command).Observe(d.Seconds())
+}
+
+// ObserveScrape records that the statistics were read, and whether that
+// failed for any of the groups.
+func (s *ScrapeStats) ObserveScrape(failed bool) {
+	s.scrapes.Inc()
+	if failed {
+		s.scrapeErrors.Inc()
+	}
+}
+
+// ObserveTimeout records that a call to the Management Interface timed out.
+func (s *ScrapeStats) ObserveTimeout() {
+	s.timeouts.Inc()
+}
+
+// ObserveRetry records that a call to the Management Interface is retried.
+func (s *ScrapeStats) ObserveRetry() {
+	s.retries.Inc()
+}
+
+// ObserveRejected records that the Management Interface wasn't called for a
+// scrape because the circuit breaker is open.
+func (s *ScrapeStats) ObserveRejected() {
+	s.rejected.Inc()
+}
+
+// SetCircuitOpen records whether the circuit breaker is open.
+func (s *ScrapeStats) SetCircuitOpen(open bool) {
+	if open {
+		s.circuitOpen.Set(1)
+	} else {
+		s.circuitOpen.Set(0)
+	}
+}
+
+func (s *ScrapeStats) collectors() []prometheus.Collector {
+	return []prometheus.Collector{s.roundtrips, s.scrapes, s.scrapeErrors, s.timeouts, s.retries, s.circuitOpen, s.rejected}
+}
+
 type scrapeProcessor struct {
-	upMetric metric
-	upStatus float64
+	upMetric             metric
+	groupMetric          metric
+	statisticsMetric     metric
+	parseErrorsMetric    metric
+	registerErrorsMetric metric
+	scrape               Scrape
 }
 
 // Describe implements prometheus.Collector.
 func (p scrapeProcessor) Describe(ch chan<- *prometheus.Desc) {
 	ch <- p.upMetric.Desc
+	ch <- p.groupMetric.Desc
+	ch <- p.statisticsMetric.Desc
+	ch <- p.parseErrorsMetric.Desc
+	ch <- p.registerErrorsMetric.Desc
+	if p.scrape.Stats != nil {
+		for _, c := range p.scrape.Stats.collectors() {
+			c.Describe(ch)
+		}
+	}
 }
 
 // Collect implements prometheus.Collector.
@@ -19,15 +156,53 @@ func (p scrapeProcessor) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		p.upMetric.Desc,
 		p.upMetric.ValueType,
-		p.upStatus,
+		p.scrape.Up,
 	)
+	for group, status := range p.scrape.Groups {
+		ch <- prometheus.MustNewConstMetric(
+			p.groupMetric.Desc,
+			p.groupMetric.ValueType,
+			status,
+			group,
+		)
+	}
+	for module, count := range p.scrape.Statistics {
+		ch <- prometheus.MustNewConstMetric(
+			p.statisticsMetric.Desc,
+			p.statisticsMetric.ValueType,
+			float64(count),
+			module,
+		)
+	}
+	for module, count := range p.scrape.ParseErrors {
+		ch <- prometheus.MustNewConstMetric(
+			p.parseErrorsMetric.Desc,
+			p.parseErrorsMetric.ValueType,
+			float64(count),
+			module,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		p.registerErrorsMetric.Desc,
+		p.registerErrorsMetric.ValueType,
+		float64(p.scrape.RegisterErrors),
+	)
+	if p.scrape.Stats != nil {
+		for _, c := range p.scrape.Stats.collectors() {
+			c.Collect(ch)
+		}
+	}
 }
 
 // NewScrapeProcessor is used to export meta metrics about the exporter/OpenSIPS such as up status,
 // time to scrape, metrics processed, scrape count etc.
-func NewScrapeProcessor(upStatus float64) prometheus.Collector {
+func NewScrapeProcessor(s Scrape) prometheus.Collector {
 	return &scrapeProcessor{
-		upMetric: newMetric("", "up", "Whether the opensips exporter could read metrics from the Management Interface socket. (i.e. is OpenSIPS up)", []string{}, prometheus.GaugeValue),
-		upStatus: upStatus,
+		upMetric:             newMetric("", "up", "Whether the opensips exporter could read metrics from the Management Interface socket. (i.e. is OpenSIPS up)", []string{}, prometheus.GaugeValue),
+		groupMetric:          newMetric("scrape", "group_success", "Whether the opensips exporter could read the statistics of the group from the Management Interface.", []string{"group"}, prometheus.GaugeValue),
+		statisticsMetric:     newMetric("scrape", "statistics", "Number of statistics of the module read from the Management Interface.", []string{"module"}, prometheus.GaugeValue),
+		parseErrorsMetric:    newMetric("scrape", "parse_errors", "Number of statistics of the module read from the Management Interface that couldn't be parsed.", []string{"module"}, prometheus.GaugeValue),
+		registerErrorsMetric: newMetric("scrape", "register_errors", "Number of processors that couldn't be registered, e.g. because there were no statistics for them.", []string{}, prometheus.GaugeValue),
+		scrape:               s,
 	}
 }
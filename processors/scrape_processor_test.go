@@ -0,0 +1,62 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScrapeProcessor(t *testing.T) {
+	stats := NewScrapeStats()
+	stats.ObserveScrape(false)
+	stats.ObserveScrape(true)
+	stats.ObserveScrape(false)
+	stats.ObserveRoundtrip("mi_datagram", "get_statistics", 2*time.Millisecond)
+	stats.ObserveRoundtrip("mi_datagram", "get_statistics", 3*time.Millisecond)
+	stats.ObserveRoundtrip("mi_datagram", "ds_list", time.Millisecond)
+	stats.ObserveTimeout()
+	stats.ObserveRetry()
+	stats.ObserveRetry()
+	stats.ObserveRejected()
+	stats.SetCircuitOpen(true)
+
+	metrics := gatheredMetrics(t, NewScrapeProcessor(Scrape{
+		Up:             1,
+		Groups:         map[string]float64{"core:": 1, "tm:": 0},
+		Statistics:     map[string]int{"core": 20, "shmem": 6},
+		ParseErrors:    map[string]int{"shmem": 1},
+		RegisterErrors: 2,
+		Stats:          stats,
+	}))
+	expected := []string{
+		`opensips_mi_circuit_open 1`,
+		`opensips_mi_circuit_rejected_total 1`,
+		`opensips_mi_retries_total 2`,
+		`opensips_mi_roundtrip_duration_seconds_count{command="ds_list",transport="mi_datagram"} 1`,
+		`opensips_mi_roundtrip_duration_seconds_count{command="get_statistics",transport="mi_datagram"} 2`,
+		`opensips_mi_timeouts_total 1`,
+		`opensips_scrape_errors_total 1`,
+		`opensips_scrape_group_success{group="core:"} 1`,
+		`opensips_scrape_group_success{group="tm:"} 0`,
+		`opensips_scrape_parse_errors{module="shmem"} 1`,
+		`opensips_scrape_register_errors 2`,
+		`opensips_scrape_statistics{module="core"} 20`,
+		`opensips_scrape_statistics{module="shmem"} 6`,
+		`opensips_scrape_total 3`,
+		`opensips_up 1`,
+	}
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("expected %q, got %q", expected, metrics)
+	}
+}
+
+func TestScrapeProcessorWithoutStats(t *testing.T) {
+	metrics := gatheredMetrics(t, NewScrapeProcessor(Scrape{}))
+	expected := []string{
+		`opensips_scrape_register_errors 0`,
+		`opensips_up 0`,
+	}
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("expected %q, got %q", expected, metrics)
+	}
+}
@@ -9,7 +9,7 @@ import (
 // doc: http://www.opensips.org/Documentation/Interface-CoreStatistics-1-11#toc21
 // src: https://github.com/OpenSIPS/opensips/blob/1.11/mem/shm_mem.c#L52
 type shmemProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 var shmemLabelNames = []string{}
@@ -23,10 +23,8 @@ var shmemMetrics = map[string]metric{
 }
 
 func init() {
-	for metric := range shmemMetrics {
-		OpensipsProcessors[metric] = shmemProcessorFunc
-	}
 	OpensipsProcessors["shmem:"] = shmemProcessorFunc
+	knownStatistics["shmem"] = knownMetrics(shmemMetrics)
 }
 
 // Describe implements prometheus.Collector.
@@ -38,51 +36,49 @@ func (p shmemProcessor) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (p shmemProcessor) Collect(ch chan<- prometheus.Metric) {
-	for _, s := range p.statistics {
-		if s.Module == "shmem" {
-			switch s.Name {
-			case "total_size":
-				ch <- prometheus.MustNewConstMetric(
-					shmemMetrics["total_size"].Desc,
-					shmemMetrics["total_size"].ValueType,
-					s.Value,
-				)
-			case "used_size":
-				ch <- prometheus.MustNewConstMetric(
-					shmemMetrics["used_size"].Desc,
-					shmemMetrics["used_size"].ValueType,
-					s.Value,
-				)
-			case "real_used_size":
-				ch <- prometheus.MustNewConstMetric(
-					shmemMetrics["real_used_size"].Desc,
-					shmemMetrics["real_used_size"].ValueType,
-					s.Value,
-				)
-			case "max_used_size":
-				ch <- prometheus.MustNewConstMetric(
-					shmemMetrics["max_used_size"].Desc,
-					shmemMetrics["max_used_size"].ValueType,
-					s.Value,
-				)
-			case "free_size":
-				ch <- prometheus.MustNewConstMetric(
-					shmemMetrics["free_size"].Desc,
-					shmemMetrics["free_size"].ValueType,
-					s.Value,
-				)
-			case "fragments":
-				ch <- prometheus.MustNewConstMetric(
-					shmemMetrics["fragments"].Desc,
-					shmemMetrics["fragments"].ValueType,
-					s.Value,
-				)
-			}
+	for _, s := range p.statistics.Module("shmem") {
+		switch s.Name {
+		case "total_size":
+			ch <- prometheus.MustNewConstMetric(
+				shmemMetrics["total_size"].Desc,
+				shmemMetrics["total_size"].ValueType,
+				s.Value,
+			)
+		case "used_size":
+			ch <- prometheus.MustNewConstMetric(
+				shmemMetrics["used_size"].Desc,
+				shmemMetrics["used_size"].ValueType,
+				s.Value,
+			)
+		case "real_used_size":
+			ch <- prometheus.MustNewConstMetric(
+				shmemMetrics["real_used_size"].Desc,
+				shmemMetrics["real_used_size"].ValueType,
+				s.Value,
+			)
+		case "max_used_size":
+			ch <- prometheus.MustNewConstMetric(
+				shmemMetrics["max_used_size"].Desc,
+				shmemMetrics["max_used_size"].ValueType,
+				s.Value,
+			)
+		case "free_size":
+			ch <- prometheus.MustNewConstMetric(
+				shmemMetrics["free_size"].Desc,
+				shmemMetrics["free_size"].ValueType,
+				s.Value,
+			)
+		case "fragments":
+			ch <- prometheus.MustNewConstMetric(
+				shmemMetrics["fragments"].Desc,
+				shmemMetrics["fragments"].ValueType,
+				s.Value,
+			)
 		}
 	}
 }
 
-func shmemProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func shmemProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &shmemProcessor{
 		statistics: s,
 	}
@@ -8,7 +8,7 @@ import (
 // tmxProcessor exposes metrics for stateful processing of SIP transactions.
 // doc: https://kamailio.org/docs/modules/4.4.x/modules/tmx.html#idp23886596
 type tmxProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 var tmxLabelNames = []string{}
@@ -30,10 +30,8 @@ var tmxMetrics = map[string]metric{
 }
 
 func init() {
-	for metric := range tmxMetrics {
-		OpensipsProcessors[metric] = tmxProcessorFunc
-	}
 	OpensipsProcessors["tmx:"] = tmxProcessorFunc
+	knownStatistics["tmx"] = knownMetrics(tmxMetrics)
 }
 
 // Describe implements prometheus.Collector.
@@ -45,109 +43,107 @@ func (p tmxProcessor) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (p tmxProcessor) Collect(ch chan<- prometheus.Metric) {
-	for _, s := range p.statistics {
-		if s.Module == "tmx" {
-			switch s.Name {
-			case "UAS_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["UAS_transactions"].Desc,
-					tmxMetrics["UAS_transactions"].ValueType,
-					s.Value,
-				)
-			case "UAC_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["UAC_transactions"].Desc,
-					tmxMetrics["UAC_transactions"].ValueType,
-					s.Value,
-				)
-			case "2xx_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["2xx_transactions"].Desc,
-					tmxMetrics["2xx_transactions"].ValueType,
-					s.Value,
-					"2xx",
-				)
-			case "3xx_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["3xx_transactions"].Desc,
-					tmxMetrics["3xx_transactions"].ValueType,
-					s.Value,
-					"3xx",
-				)
-			case "4xx_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["4xx_transactions"].Desc,
-					tmxMetrics["4xx_transactions"].ValueType,
-					s.Value,
-					"4xx",
-				)
-			case "5xx_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["5xx_transactions"].Desc,
-					tmxMetrics["5xx_transactions"].ValueType,
-					s.Value,
-					"5xx",
-				)
-			case "6xx_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["6xx_transactions"].Desc,
-					tmxMetrics["6xx_transactions"].ValueType,
-					s.Value,
-					"6xx",
-				)
-			case "inuse_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["inuse_transactions"].Desc,
-					tmxMetrics["inuse_transactions"].ValueType,
-					s.Value,
-				)
-			case "active_transactions":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["active_transactions"].Desc,
-					tmxMetrics["active_transactions"].ValueType,
-					s.Value,
-				)
-			case "rpl_received":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["rpl_received"].Desc,
-					tmxMetrics["rpl_received"].ValueType,
-					s.Value,
-					"received",
-				)
-			case "rpl_absorbed":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["rpl_absorbed"].Desc,
-					tmxMetrics["rpl_absorbed"].ValueType,
-					s.Value,
-					"absorbed",
-				)
-			case "rpl_relayed":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["rpl_relayed"].Desc,
-					tmxMetrics["rpl_relayed"].ValueType,
-					s.Value,
-					"relayed",
-				)
-			case "rpl_generated":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["rpl_generated"].Desc,
-					tmxMetrics["rpl_generated"].ValueType,
-					s.Value,
-					"generated",
-				)
-			case "rpl_sent":
-				ch <- prometheus.MustNewConstMetric(
-					tmxMetrics["rpl_sent"].Desc,
-					tmxMetrics["rpl_sent"].ValueType,
-					s.Value,
-					"sent",
-				)
-			}
+	for _, s := range p.statistics.Module("tmx") {
+		switch s.Name {
+		case "UAS_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["UAS_transactions"].Desc,
+				tmxMetrics["UAS_transactions"].ValueType,
+				s.Value,
+			)
+		case "UAC_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["UAC_transactions"].Desc,
+				tmxMetrics["UAC_transactions"].ValueType,
+				s.Value,
+			)
+		case "2xx_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["2xx_transactions"].Desc,
+				tmxMetrics["2xx_transactions"].ValueType,
+				s.Value,
+				"2xx",
+			)
+		case "3xx_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["3xx_transactions"].Desc,
+				tmxMetrics["3xx_transactions"].ValueType,
+				s.Value,
+				"3xx",
+			)
+		case "4xx_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["4xx_transactions"].Desc,
+				tmxMetrics["4xx_transactions"].ValueType,
+				s.Value,
+				"4xx",
+			)
+		case "5xx_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["5xx_transactions"].Desc,
+				tmxMetrics["5xx_transactions"].ValueType,
+				s.Value,
+				"5xx",
+			)
+		case "6xx_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["6xx_transactions"].Desc,
+				tmxMetrics["6xx_transactions"].ValueType,
+				s.Value,
+				"6xx",
+			)
+		case "inuse_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["inuse_transactions"].Desc,
+				tmxMetrics["inuse_transactions"].ValueType,
+				s.Value,
+			)
+		case "active_transactions":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["active_transactions"].Desc,
+				tmxMetrics["active_transactions"].ValueType,
+				s.Value,
+			)
+		case "rpl_received":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["rpl_received"].Desc,
+				tmxMetrics["rpl_received"].ValueType,
+				s.Value,
+				"received",
+			)
+		case "rpl_absorbed":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["rpl_absorbed"].Desc,
+				tmxMetrics["rpl_absorbed"].ValueType,
+				s.Value,
+				"absorbed",
+			)
+		case "rpl_relayed":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["rpl_relayed"].Desc,
+				tmxMetrics["rpl_relayed"].ValueType,
+				s.Value,
+				"relayed",
+			)
+		case "rpl_generated":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["rpl_generated"].Desc,
+				tmxMetrics["rpl_generated"].ValueType,
+				s.Value,
+				"generated",
+			)
+		case "rpl_sent":
+			ch <- prometheus.MustNewConstMetric(
+				tmxMetrics["rpl_sent"].Desc,
+				tmxMetrics["rpl_sent"].ValueType,
+				s.Value,
+				"sent",
+			)
 		}
 	}
 }
 
-func tmxProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func tmxProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &tmxProcessor{
 		statistics: s,
 	}
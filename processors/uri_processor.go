@@ -9,7 +9,7 @@ import (
 // doc: http://www.opensips.org/html/docs/modules/1.11.x/uri.html
 // src: https://github.com/OpenSIPS/opensips/blob/1.11/modules/uri/uri_mod.c#L191
 type uriProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 var uriLabelNames = []string{}
@@ -19,10 +19,8 @@ var uriMetrics = map[string]metric{
 }
 
 func init() {
-	for metric := range uriMetrics {
-		OpensipsProcessors[metric] = uriProcessorFunc
-	}
 	OpensipsProcessors["uri:"] = uriProcessorFunc
+	knownStatistics["uri"] = knownMetrics(uriMetrics)
 }
 
 // Describe implements prometheus.Collector.
@@ -34,27 +32,25 @@ func (p uriProcessor) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (p uriProcessor) Collect(ch chan<- prometheus.Metric) {
-	for _, s := range p.statistics {
-		if s.Module == "uri" {
-			switch s.Name {
-			case "positive":
-				ch <- prometheus.MustNewConstMetric(
-					uriMetrics["positive"].Desc,
-					uriMetrics["positive"].ValueType,
-					s.Value,
-				)
-			case "negative_checks":
-				ch <- prometheus.MustNewConstMetric(
-					uriMetrics["negative_checks"].Desc,
-					uriMetrics["negative_checks"].ValueType,
-					s.Value,
-				)
-			}
+	for _, s := range p.statistics.Module("uri") {
+		switch s.Name {
+		case "positive":
+			ch <- prometheus.MustNewConstMetric(
+				uriMetrics["positive"].Desc,
+				uriMetrics["positive"].ValueType,
+				s.Value,
+			)
+		case "negative_checks":
+			ch <- prometheus.MustNewConstMetric(
+				uriMetrics["negative_checks"].Desc,
+				uriMetrics["negative_checks"].ValueType,
+				s.Value,
+			)
 		}
 	}
 }
 
-func uriProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func uriProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &uriProcessor{
 		statistics: s,
 	}
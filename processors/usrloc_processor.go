@@ -11,7 +11,7 @@ import (
 // doc: http://www.opensips.org/html/docs/modules/1.11.x/usrloc.html#idp5699792
 // src: Not clear
 type usrlocProcessor struct {
-	statistics map[string]opensips.Statistic
+	statistics opensips.Statistics
 }
 
 type usrlocMetric struct {
@@ -21,9 +21,17 @@ type usrlocMetric struct {
 
 func init() {
 	OpensipsProcessors["usrloc:"] = usrlocProcessorFunc
-	OpensipsProcessors["contacts"] = usrlocProcessorFunc
-	OpensipsProcessors["users"] = usrlocProcessorFunc
-	OpensipsProcessors["expires"] = usrlocProcessorFunc
+	knownStatistics["usrloc"] = func(name string) bool {
+		if name == "registered_users" {
+			return true
+		}
+		split := strings.LastIndex(name, "-")
+		switch name[split+1:] {
+		case "users", "contacts", "expires":
+			return split != -1
+		}
+		return false
+	}
 }
 
 // Describe implements prometheus.Collector.
@@ -40,21 +48,21 @@ func (p usrlocProcessor) Collect(ch chan<- prometheus.Metric) {
 			ch <- prometheus.MustNewConstMetric(
 				u.metric.Desc,
 				u.metric.ValueType,
-				p.statistics[key].Value,
+				p.statistics.Value("usrloc", key),
 				u.domain,
 			)
 		} else {
 			ch <- prometheus.MustNewConstMetric(
 				u.metric.Desc,
 				u.metric.ValueType,
-				p.statistics[key].Value,
+				p.statistics.Value("usrloc", key),
 			)
 		}
 
 	}
 }
 
-func usrlocProcessorFunc(s map[string]opensips.Statistic) prometheus.Collector {
+func usrlocProcessorFunc(s opensips.Statistics) prometheus.Collector {
 	return &usrlocProcessor{
 		statistics: s,
 	}
@@ -64,12 +72,7 @@ func (p usrlocProcessor) usrlocMetrics() map[string]usrlocMetric {
 	var metrics = map[string]usrlocMetric{}
 
 	// Get all usrloc statistics
-	var stats []opensips.Statistic
-	for _, s := range p.statistics {
-		if s.Module == "usrloc" {
-			stats = append(stats, s)
-		}
-	}
+	stats := p.statistics.Module("usrloc")
 
 	for _, s := range stats {
 		split := strings.LastIndex(s.Name, "-")
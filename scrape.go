@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/processors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxConcurrentFetches is the maximum number of get_statistics calls done in
+// parallel for a single scrape.
+const maxConcurrentFetches = 4
+
+// fetchStatistics gets the statistics for every target (e.g. "core:")
+// separately, at most maxConcurrentFetches at a time, and merges the results.
+// The returned map holds the error for every target that failed, so a missing
+// or slow module doesn't affect the statistics of the others. The statistics
+// of a target that could be partly parsed are merged as well, its error is an
+// *opensips.ParseError.
+func fetchStatistics(ctx context.Context, source opensips.StatisticsSource, targets []string) (opensips.Statistics, map[string]error) {
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		sem        = make(chan struct{}, maxConcurrentFetches)
+		statistics = make(opensips.Statistics)
+		errs       = make(map[string]error)
+	)
+	for _, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(target string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s, err := source.GetStatistics(ctx, target)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[target] = err
+				var parseErr *opensips.ParseError
+				if !errors.As(err, &parseErr) {
+					return
+				}
+			}
+			statistics.Merge(s)
+		}(target)
+	}
+	wg.Wait()
+	return statistics, errs
+}
+
+// collectGroups returns the statistics groups to collect from source for r:
+// the ones selected by the collect[] query parameters, else the
+// defaultGroups.
+func collectGroups(r *http.Request, source *instrumentedSource, defaults []string) []string {
+	if collect := r.URL.Query()["collect[]"]; len(collect) > 0 {
+		return collect
+	}
+	return defaultGroups(source, defaults)
+}
+
+// defaultGroups returns defaults, or all groups there's a processor for when
+// it's empty. The groups of command processors are left out of the latter
+// when source can't call management functions.
+func defaultGroups(source *instrumentedSource, defaults []string) []string {
+	if len(defaults) > 0 {
+		return defaults
+	}
+	// Collect everything if nothing is specified
+	groups := collectAll
+	if *fallback {
+		// Include the modules without processor.
+		groups = []string{"all", "build_info"}
+	}
+	if source.canCall() {
+		return groups
+	}
+	var statistics []string
+	for _, group := range groups {
+		if _, ok := processors.CommandProcessors[group]; !ok {
+			statistics = append(statistics, group)
+		}
+	}
+	return statistics
+}
+
+// snapshot holds the statistics of the collect groups read at once.
+type snapshot struct {
+	time       time.Time
+	collect    []string
+	statistics opensips.Statistics
+	// errs holds the error for every group that couldn't be read.
+	errs map[string]error
+	// parseErrors holds the number of statistics that couldn't be parsed per
+	// module.
+	parseErrors map[string]int
+	stats       *processors.ScrapeStats
+	// detected is set when the Management Interface was detected with the
+	// auto protocol.
+	detected *opensips.Detected
+	// collectors holds the collectors of the command processors.
+	collectors []prometheus.Collector
+}
+
+// fetchSnapshot fetches the statistics of the collect groups from source, and
+// then runs the command processors of the groups that have one.
+func fetchSnapshot(ctx context.Context, source *instrumentedSource, collect []string) *snapshot {
+	s := &snapshot{
+		time:        time.Now(),
+		collect:     collect,
+		parseErrors: make(map[string]int),
+		stats:       source.stats,
+	}
+	if !source.allowScrape() {
+		s.statistics = make(opensips.Statistics)
+		s.errs = make(map[string]error)
+		for _, group := range collect {
+			s.errs[group] = errCircuitOpen
+		}
+		source.stats.ObserveScrape(len(collect) > 0)
+		return s
+	}
+	var targets, commands []string
+	for _, group := range collect {
+		if _, ok := processors.CommandProcessors[group]; ok {
+			commands = append(commands, group)
+		} else {
+			targets = append(targets, group)
+		}
+	}
+	s.statistics, s.errs = fetchStatistics(ctx, source, targets)
+	for _, group := range commands {
+		c, err := source.command(group).Collector(ctx, source, s.statistics)
+		if err != nil {
+			log.Printf("Error encountered while calling the Management Interface for %s: %v", group, err)
+			s.errs[group] = err
+			continue
+		}
+		s.collectors = append(s.collectors, c)
+	}
+	source.recordScrape(ctx, len(collect) > 0 && scrapeFailed(collect, s.errs))
+	for _, target := range targets {
+		err, ok := s.errs[target]
+		if !ok {
+			continue
+		}
+		var parseErr *opensips.ParseError
+		if errors.As(err, &parseErr) {
+			log.Printf("Error encountered while parsing %s statistics from the Management Interface: %v", target, err)
+			for module, n := range parseErr.Modules {
+				s.parseErrors[module] += n
+			}
+			delete(s.errs, target)
+			continue
+		}
+		log.Printf("Error encountered while reading %s statistics from the Management Interface: %v", target, err)
+	}
+	source.stats.ObserveScrape(len(s.errs) > 0)
+	if d, ok := source.detected(); ok {
+		s.detected = &d
+	}
+	return s
+}
+
+// scrapeFailed reports whether every one of the collect groups failed because
+// OpenSIPS couldn't be reached or didn't reply in time, see failed.
+func scrapeFailed(collect []string, errs map[string]error) bool {
+	for _, group := range collect {
+		if !failed(errs[group]) {
+			return false
+		}
+	}
+	return true
+}
+
+// success reports whether the statistics of any of the groups could be read.
+func (s *snapshot) success() bool {
+	for _, target := range s.collect {
+		if _, failed := s.errs[target]; !failed {
+			return true
+		}
+	}
+	return false
+}
+
+// scrape fetches the statistics of the collect groups from source, and
+// returns a registry with the processors for them.
+func scrape(ctx context.Context, source *instrumentedSource, collect []string) *prometheus.Registry {
+	return newRegistry(fetchSnapshot(ctx, source, collect))
+}
+
+// scrapeTimeoutHeader is the header in which Prometheus sends the scrape
+// timeout.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// scrapeContext returns the context for reading the statistics to serve r. It
+// is done when the client disconnects, or when the scrape timeout Prometheus
+// sends minus the -timeout_offset has passed.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if v := r.Header.Get(scrapeTimeoutHeader); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Printf("Invalid %s header %q: %v", scrapeTimeoutHeader, v, err)
+		} else if timeout := time.Duration(seconds * float64(time.Second)); timeout > 0 {
+			// Leave time to serve the metrics, unless the timeout is too short
+			// for that.
+			if timeout > *timeoutOffset {
+				timeout -= *timeoutOffset
+			}
+			return context.WithTimeout(r.Context(), timeout)
+		}
+	}
+	return context.WithCancel(r.Context())
+}
+
+// newRegistry returns a registry with the processors for the statistics in
+// s, and the extra collectors.
+func newRegistry(s *snapshot, extra ...prometheus.Collector) *prometheus.Registry {
+	collectors := make(map[prometheus.Collector]bool)
+	for _, c := range extra {
+		collectors[c] = true
+	}
+	for _, c := range s.collectors {
+		collectors[c] = true
+	}
+
+	result := processors.Scrape{
+		Groups:      make(map[string]float64),
+		Statistics:  make(map[string]int),
+		ParseErrors: s.parseErrors,
+		Stats:       s.stats,
+	}
+	for _, target := range s.collect {
+		if _, ok := s.errs[target]; ok {
+			result.Groups[target] = 0
+			continue
+		}
+		result.Groups[target] = 1
+		result.Up = 1
+	}
+	for _, statistic := range s.statistics {
+		result.Statistics[statistic.Module]++
+	}
+
+	// The processors are selected by the modules of the statistics read, so
+	// a group naming a single statistic (e.g. "free_size") gets the processor
+	// of every module that has it.
+	selected := map[string]processors.Processor{}
+	for _, processor := range s.collect {
+		if _, failed := s.errs[processor]; !failed && processor == "all" {
+			for group, p := range processors.OpensipsProcessors {
+				selected[group] = p
+			}
+		}
+	}
+	for _, module := range s.statistics.Modules() {
+		if p, ok := processors.OpensipsProcessors[module+":"]; ok {
+			selected[module+":"] = p
+		}
+	}
+	var selectedProcessors = map[string]bool{}
+	for _, p := range selected {
+		processorFunc := fmt.Sprintf("%p", p)
+		if _, ok := selectedProcessors[processorFunc]; !ok {
+			selectedProcessors[processorFunc] = true
+			collectors[p(s.statistics)] = true
+		}
+	}
+	if *fallback {
+		collectors[processors.NewFallbackProcessor(s.statistics)] = true
+	}
+	if d := s.detected; d != nil {
+		collectors[processors.NewMIInfoProcessor(d.Protocol, d.Format.String(), d.Version)] = true
+	}
+
+	registry := prometheus.NewRegistry()
+	for collector := range collectors {
+		err := registry.Register(collector)
+		if err != nil {
+			log.Printf("Problems registering the %T processor (could be due to no metrics found for this processor). Error: %v\n", collector, err)
+			result.RegisterErrors++
+		}
+	}
+	// The scrape processor is registered last, to report the processors that
+	// couldn't be registered.
+	if err := registry.Register(processors.NewScrapeProcessor(result)); err != nil {
+		log.Printf("Problems registering the scrape processor. Error: %v\n", err)
+	}
+	return registry
+}
+
+// serveMetrics serves the metrics of the gatherers.
+func serveMetrics(w http.ResponseWriter, r *http.Request, gatherers prometheus.Gatherers) {
+	// Delegate http serving to Prometheus client library, which will call collector.Collect.
+	h := promhttp.HandlerFor(gatherers,
+		promhttp.HandlerOpts{
+			ErrorHandling: promhttp.ContinueOnError,
+		})
+	h.ServeHTTP(w, r)
+}
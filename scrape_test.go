@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMain(m *testing.M) {
+	// The flags are only set up by main.
+	fallback = new(bool)
+	os.Exit(m.Run())
+}
+
+// gatheredMetrics returns the values of the counters, gauges and untyped
+// metrics gathered from g, keyed by their name and labels, e.g.
+// `opensips_scrape_group_success{group="core:"}`.
+func gatheredMetrics(t *testing.T, g prometheus.Gatherer) map[string]float64 {
+	t.Helper()
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			sort.Strings(labels)
+			name := mf.GetName()
+			if len(labels) > 0 {
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+			switch {
+			case m.Counter != nil:
+				metrics[name] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				metrics[name] = m.GetGauge().GetValue()
+			case m.Untyped != nil:
+				metrics[name] = m.GetUntyped().GetValue()
+			}
+		}
+	}
+	return metrics
+}
+
+// expectMetrics reports the metrics in expected that don't have the expected
+// value in metrics.
+func expectMetrics(t *testing.T, metrics, expected map[string]float64) {
+	t.Helper()
+	for name, value := range expected {
+		got, ok := metrics[name]
+		if !ok {
+			t.Errorf("expected %s, it's missing", name)
+			continue
+		}
+		if got != value {
+			t.Errorf("expected %s %v, got %v", name, value, got)
+		}
+	}
+}
+
+func TestScrapeCounters(t *testing.T) {
+	fake := &fakeSource{errs: []error{errUnreachable}}
+	s := newFakeSource(fake, 0, 0)
+	collect := []string{"core:"}
+	scrape(context.Background(), s, collect)
+	scrape(context.Background(), s, collect)
+	metrics := gatheredMetrics(t, scrape(context.Background(), s, collect))
+	expectMetrics(t, metrics, map[string]float64{
+		"opensips_scrape_total":        3,
+		"opensips_scrape_errors_total": 1,
+		"opensips_up":                  1,
+	})
+}
+
+func TestNewRegistry(t *testing.T) {
+	statistics := make(opensips.Statistics)
+	for _, s := range []opensips.Statistic{
+		{Module: "core", Name: "rcv_requests", Value: 42},
+		{Module: "core", Name: "rcv_replies", Value: 40},
+		{Module: "shmem", Name: "total_size", Value: 1024},
+	} {
+		statistics.Add(s)
+	}
+	s := newFakeSource(&fakeSource{}, 0, 0)
+	registry := newRegistry(&snapshot{
+		collect:     []string{"core:", "shmem:"},
+		statistics:  statistics,
+		errs:        make(map[string]error),
+		parseErrors: map[string]int{"shmem": 2},
+		stats:       s.stats,
+	},
+		// A collector with an invalid metric name can't be registered.
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "invalid-name", Help: "Invalid."}),
+	)
+	expectMetrics(t, gatheredMetrics(t, registry), map[string]float64{
+		`opensips_scrape_statistics{module="core"}`:    2,
+		`opensips_scrape_statistics{module="shmem"}`:   1,
+		`opensips_scrape_parse_errors{module="shmem"}`: 2,
+		`opensips_scrape_register_errors`:              1,
+		`opensips_core_requests_total`:                 42,
+		`opensips_shmem_total_size`:                    1024,
+	})
+}
+
+func TestScrapePartialFailure(t *testing.T) {
+	fake := &fakeSource{
+		targetErrs: map[string]error{"tm:": errUnreachable},
+		statistics: map[string][]opensips.Statistic{
+			"core:":  {{Module: "core", Name: "rcv_requests", Value: 42}},
+			"shmem:": {{Module: "shmem", Name: "total_size", Value: 1024}},
+			"tm:":    {{Module: "tm", Name: "UAS_transactions", Value: 7}},
+		},
+	}
+	s := newFakeSource(fake, 0, 0)
+	metrics := gatheredMetrics(t, scrape(context.Background(), s, []string{"core:", "shmem:", "tm:"}))
+	expectMetrics(t, metrics, map[string]float64{
+		"opensips_up": 1,
+		`opensips_scrape_group_success{group="core:"}`:  1,
+		`opensips_scrape_group_success{group="shmem:"}`: 1,
+		`opensips_scrape_group_success{group="tm:"}`:    0,
+		`opensips_core_requests_total`:                  42,
+		`opensips_shmem_total_size`:                     1024,
+		"opensips_scrape_errors_total":                  1,
+	})
+	for name := range metrics {
+		if strings.HasPrefix(name, "opensips_tm_") {
+			t.Errorf("expected no metrics of the failed group, got %s", name)
+		}
+	}
+}
+
+func TestScrapeAllGroupsFailed(t *testing.T) {
+	s := newFakeSource(&fakeSource{down: true}, 0, 0)
+	metrics := gatheredMetrics(t, scrape(context.Background(), s, []string{"core:", "shmem:"}))
+	expectMetrics(t, metrics, map[string]float64{
+		"opensips_up": 0,
+		`opensips_scrape_group_success{group="core:"}`:  0,
+		`opensips_scrape_group_success{group="shmem:"}`: 0,
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/processors"
+)
+
+// errCircuitOpen is returned instead of calling the Management Interface while
+// the circuit breaker is open.
+var errCircuitOpen = errors.New("not calling the Management Interface after repeated failures")
+
+// instrumentedSource is a client for the Management Interface of an OpenSIPS,
+// along with the meta metrics about scraping it and its command processors. It
+// retries failed get_statistics calls, and has the breaker that stops the
+// scrapes for a while after repeated failures (see fetchSnapshot).
+type instrumentedSource struct {
+	opensips.StatisticsSource
+	stats *processors.ScrapeStats
+
+	retries int
+	backoff time.Duration
+	breaker *breaker
+
+	commandConfig processors.CommandConfig
+	mu            sync.Mutex
+	commands      map[string]processors.CommandProcessor
+}
+
+// newInstrumentedSource creates a client for the Management Interface using
+// protocol, which reports its roundtrips to stats and uses breaker. Its
+// command processors are created with commandConfig. The
+// retries are set up with the command line flags. With the auto protocol, the
+// transport is detected on the first call, see autoCandidates.
+func newInstrumentedSource(protocol string, c opensips.Config, stats *processors.ScrapeStats, breaker *breaker, commandConfig processors.CommandConfig) (*instrumentedSource, error) {
+	var s opensips.StatisticsSource
+	if protocol == opensips.AutoTransport {
+		s = opensips.NewAutoSource(autoCandidates(c, stats))
+	} else {
+		c.ObserveRoundtrip = roundtripObserver(protocol, stats)
+		var err error
+		s, err = opensips.Transports[protocol].New(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &instrumentedSource{
+		StatisticsSource: s,
+		stats:            stats,
+		retries:          *retries,
+		backoff:          *retryBackoff,
+		breaker:          breaker,
+		commandConfig:    commandConfig,
+		commands:         make(map[string]processors.CommandProcessor),
+	}, nil
+}
+
+// autoCandidates returns the transports to try for the auto protocol: the
+// ones for c.Address, or mi_http at -http_address and mi_datagram at -socket
+// when it's empty. Candidates without timeout get the one of their transport
+// flag.
+func autoCandidates(c opensips.Config, stats *processors.ScrapeStats) []opensips.Candidate {
+	var candidates []opensips.Candidate
+	if c.Address != "" {
+		candidates = opensips.DefaultCandidates(c.Address, c)
+	} else {
+		for _, protocol := range []string{"mi_http", "mi_datagram"} {
+			if *addresses[protocol] != "" {
+				candidates = append(candidates, opensips.DefaultCandidates(*addresses[protocol], c)...)
+			}
+		}
+	}
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.Config.Timeout == 0 {
+			candidate.Config.Timeout = transportTimeout(candidate.Protocol)
+		}
+		candidate.Config.ObserveRoundtrip = roundtripObserver(candidate.Protocol, stats)
+	}
+	return candidates
+}
+
+// roundtripObserver returns an opensips.Config.ObserveRoundtrip func which
+// reports the roundtrips through protocol to stats.
+func roundtripObserver(protocol string, stats *processors.ScrapeStats) func(string, time.Duration) {
+	return func(command string, d time.Duration) {
+		stats.ObserveRoundtrip(protocol, command, d)
+	}
+}
+
+// transportTimeout returns the timeout set with the -<protocol>_timeout flag,
+// or 0 when there's no such flag (e.g. for the auto protocol).
+func transportTimeout(protocol string) time.Duration {
+	if t, ok := timeouts[protocol]; ok {
+		return *t
+	}
+	return 0
+}
+
+// detected returns the Management Interface that was detected for s, if it
+// uses the auto protocol.
+func (s *instrumentedSource) detected() (opensips.Detected, bool) {
+	if a, ok := s.StatisticsSource.(*opensips.AutoSource); ok {
+		return a.Detected()
+	}
+	return opensips.Detected{}, false
+}
+
+// GetStatistics implements opensips.StatisticsSource. Failed calls are
+// retried, see call.
+func (s *instrumentedSource) GetStatistics(ctx context.Context, targets ...string) (opensips.Statistics, error) {
+	var statistics opensips.Statistics
+	err := s.call(ctx, s.retries, func() error {
+		var err error
+		statistics, err = s.StatisticsSource.GetStatistics(ctx, targets...)
+		return err
+	})
+	return statistics, err
+}
+
+// Call implements opensips.Caller. It fails when the transport can't call
+// management functions. Failed calls aren't retried, as management functions
+// other than get_statistics may change the state of OpenSIPS.
+func (s *instrumentedSource) Call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	c, ok := s.StatisticsSource.(opensips.Caller)
+	if !ok {
+		return nil, fmt.Errorf("%s can't call management functions", s.Transport())
+	}
+	var reply interface{}
+	err := s.call(ctx, 0, func() error {
+		var err error
+		reply, err = c.Call(ctx, method, params...)
+		return err
+	})
+	return reply, err
+}
+
+// call calls the Management Interface with f. Failed calls are retried up to
+// retries times, waiting backoff before the first retry and twice as long
+// before every next one.
+func (s *instrumentedSource) call(ctx context.Context, retries int, f func() error) error {
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if isTimeout(err) {
+			s.stats.ObserveTimeout()
+		}
+		if !failed(err) || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		s.stats.ObserveRetry()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// allowScrape reports whether the Management Interface may be called for a
+// scrape, i.e. whether the breaker isn't open.
+func (s *instrumentedSource) allowScrape() bool {
+	if !s.breaker.allow() {
+		s.stats.ObserveRejected()
+		return false
+	}
+	return true
+}
+
+// recordScrape records the outcome of a scrape in the breaker, so the breaker
+// counts scrapes and not the calls of every group.
+func (s *instrumentedSource) recordScrape(ctx context.Context, failed bool) {
+	// A scrape that is cancelled by its client says nothing about OpenSIPS.
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	s.stats.SetCircuitOpen(s.breaker.record(failed))
+}
+
+// canCall reports whether the transport of s can call management functions
+// other than get_statistics, which the command processors need.
+func (s *instrumentedSource) canCall() bool {
+	_, ok := s.StatisticsSource.(opensips.Caller)
+	return ok
+}
+
+// command returns the command processor of s for the collect group, creating
+// it when needed, or nil when there's none.
+func (s *instrumentedSource) command(group string) processors.CommandProcessor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.commands[group]; ok {
+		return p
+	}
+	newProcessor, ok := processors.CommandProcessors[group]
+	if !ok {
+		return nil
+	}
+	p := newProcessor(s.commandConfig)
+	s.commands[group] = p
+	return p
+}
+
+// failed reports whether err means that OpenSIPS couldn't be reached or
+// didn't reply in time. Errors OpenSIPS replied with, and statistics that
+// couldn't be parsed, don't count.
+func failed(err error) bool {
+	var replyErr *opensips.ReplyError
+	var parseErr *opensips.ParseError
+	return err != nil && !errors.As(err, &replyErr) && !errors.As(err, &parseErr)
+}
+
+// isTimeout reports whether err means that OpenSIPS didn't reply in time.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VoIPGRID/opensips_exporter/opensips"
+	"github.com/VoIPGRID/opensips_exporter/processors"
+)
+
+var errUnreachable = errors.New("connection refused")
+
+// fakeSource is a StatisticsSource and Caller that fails the first len(errs)
+// calls with those errors, or every call while down is set, and counts the
+// calls. The statistics of a target fail with its error in targetErrs, if
+// any, and are the ones in statistics when it's set.
+type fakeSource struct {
+	mu         sync.Mutex
+	errs       []error
+	down       bool
+	calls      int
+	closed     bool
+	targetErrs map[string]error
+	statistics map[string][]opensips.Statistic
+}
+
+func (s *fakeSource) next() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.down {
+		return errUnreachable
+	}
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func (s *fakeSource) GetStatistics(ctx context.Context, targets ...string) (opensips.Statistics, error) {
+	if err := s.next(); err != nil {
+		return nil, err
+	}
+	statistics := make(opensips.Statistics)
+	if s.statistics == nil {
+		statistics.Add(opensips.Statistic{Module: "core", Name: "rcv_requests", Value: 42})
+		return statistics, nil
+	}
+	for _, target := range targets {
+		if err := s.targetErrs[target]; err != nil {
+			return nil, err
+		}
+		for _, stat := range s.statistics[target] {
+			statistics.Add(stat)
+		}
+	}
+	return statistics, nil
+}
+
+func (s *fakeSource) Call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	if err := s.next(); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{}, nil
+}
+
+func (s *fakeSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSource) Transport() string { return "fake" }
+
+func newFakeSource(fake *fakeSource, retries, breakerFailures int) *instrumentedSource {
+	return &instrumentedSource{
+		StatisticsSource: fake,
+		stats:            processors.NewScrapeStats(),
+		retries:          retries,
+		backoff:          10 * time.Millisecond,
+		breaker:          newBreaker(breakerFailures, time.Minute),
+		commands:         make(map[string]processors.CommandProcessor),
+	}
+}
+
+func TestRetries(t *testing.T) {
+	replyErr := &opensips.ReplyError{Code: 500, Message: "command 'get_statistics' not available"}
+	tests := []struct {
+		name    string
+		errs    []error
+		retries int
+		calls   int
+		err     bool
+		backoff time.Duration
+	}{
+		{name: "success", retries: 2, calls: 1},
+		{name: "retried", errs: []error{errUnreachable}, retries: 2, calls: 2, backoff: 10 * time.Millisecond},
+		{name: "backoff doubled", errs: []error{errUnreachable, context.DeadlineExceeded}, retries: 2, calls: 3, backoff: 30 * time.Millisecond},
+		{name: "retries exhausted", errs: []error{errUnreachable, errUnreachable, errUnreachable}, retries: 2, calls: 3, err: true},
+		{name: "no retries", errs: []error{errUnreachable}, calls: 1, err: true},
+		{name: "reply error", errs: []error{replyErr}, retries: 2, calls: 1, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSource{errs: tt.errs}
+			s := newFakeSource(fake, tt.retries, 0)
+			start := time.Now()
+			_, err := s.GetStatistics(context.Background(), "core:")
+			if (err != nil) != tt.err {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+			if fake.calls != tt.calls {
+				t.Errorf("expected %d calls, got %d", tt.calls, fake.calls)
+			}
+			if elapsed := time.Since(start); elapsed < tt.backoff {
+				t.Errorf("expected to wait at least %v, waited %v", tt.backoff, elapsed)
+			}
+		})
+	}
+}
+
+func TestRetriesCancelled(t *testing.T) {
+	fake := &fakeSource{errs: []error{errUnreachable, errUnreachable}}
+	s := newFakeSource(fake, 2, 0)
+	s.backoff = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.GetStatistics(ctx, "core:"); err == nil {
+		t.Error("expected an error")
+	}
+	if fake.calls != 1 {
+		t.Errorf("expected no retry after the context is done, got %d calls", fake.calls)
+	}
+}
+
+func TestCallNotRetried(t *testing.T) {
+	fake := &fakeSource{errs: []error{errUnreachable}}
+	s := newFakeSource(fake, 2, 0)
+	if _, err := s.Call(context.Background(), "ds_reload"); err == nil {
+		t.Error("expected an error")
+	}
+	if fake.calls != 1 {
+		t.Errorf("expected management functions not to be retried, got %d calls", fake.calls)
+	}
+}
+
+func TestBreakerPerScrape(t *testing.T) {
+	collect := []string{"core:", "shmem:", "net:", "uri:", "tm:", "sl:", "usrloc:", "dialog:", "registrar:", "pkmem:", "load:", "tmx:"}
+	errs := make([]error, 2*len(collect))
+	for i := range errs {
+		errs[i] = errUnreachable
+	}
+	fake := &fakeSource{errs: errs}
+	s := newFakeSource(fake, 0, 2)
+
+	// The first failed scrape doesn't open the breaker, however many groups
+	// failed.
+	if snapshot := fetchSnapshot(context.Background(), s, collect); snapshot.success() {
+		t.Fatal("expected the first scrape to fail")
+	}
+	if !s.breaker.allow() {
+		t.Fatal("expected the breaker to stay closed after one failed scrape")
+	}
+	fetchSnapshot(context.Background(), s, collect)
+	if s.breaker.allow() {
+		t.Fatal("expected the breaker to open after two failed scrapes")
+	}
+
+	calls := fake.calls
+	snapshot := fetchSnapshot(context.Background(), s, collect)
+	if fake.calls != calls {
+		t.Errorf("expected no calls while the breaker is open, got %d", fake.calls-calls)
+	}
+	for _, group := range collect {
+		if !errors.Is(snapshot.errs[group], errCircuitOpen) {
+			t.Errorf("expected %s to fail with the breaker open, got %v", group, snapshot.errs[group])
+		}
+	}
+}
+
+func TestBreakerPartialFailure(t *testing.T) {
+	fake := &fakeSource{errs: []error{errUnreachable}}
+	s := newFakeSource(fake, 0, 1)
+	snapshot := fetchSnapshot(context.Background(), s, []string{"core:", "shmem:"})
+	if len(snapshot.errs) != 1 {
+		t.Fatalf("expected one of the groups to fail, got %v", snapshot.errs)
+	}
+	if !s.breaker.allow() {
+		t.Error("expected a scrape with groups read not to open the breaker")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// webConfig holds the TLS and authentication settings of the listener, in the
+// format of the Prometheus web config file:
+//
+//	tls_server_config:
+//	  cert_file: /etc/opensips_exporter/tls.crt
+//	  key_file: /etc/opensips_exporter/tls.key
+//	  client_auth_type: RequireAndVerifyClientCert
+//	  client_ca_file: /etc/opensips_exporter/ca.crt
+//	basic_auth_users:
+//	  prometheus: $2y$10$...
+type webConfig struct {
+	TLSServerConfig *tlsServerConfig `yaml:"tls_server_config"`
+	// BasicAuthUsers holds the bcrypt hash of the password of every user.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+type tlsServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// loadWebConfig reads and validates the web config file at path.
+func loadWebConfig(path string) (*webConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c webConfig
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if t := c.TLSServerConfig; t != nil {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, errors.New("tls_server_config needs both a cert_file and a key_file")
+		}
+		authType, ok := clientAuthTypes[t.ClientAuthType]
+		if !ok {
+			return nil, fmt.Errorf("invalid client_auth_type %q", t.ClientAuthType)
+		}
+		if t.ClientCAFile == "" && (authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert) {
+			return nil, fmt.Errorf("client_auth_type %s needs a client_ca_file", t.ClientAuthType)
+		}
+	}
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash for user %s: %w", user, err)
+		}
+	}
+	return &c, nil
+}
+
+// tlsConfig returns the TLS config for the listener. The certificate is
+// loaded again when its files change.
+func (t *tlsServerConfig) tlsConfig() (*tls.Config, error) {
+	certs := &certReloader{certFile: t.CertFile, keyFile: t.KeyFile}
+	if _, err := certs.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		ClientAuth:     clientAuthTypes[t.ClientAuthType],
+		MinVersion:     tls.VersionTLS12,
+	}
+	if t.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the client_ca_file: %w", err)
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client_ca_file %s", t.ClientCAFile)
+		}
+	}
+	return c, nil
+}
+
+// certReloader loads a certificate, and loads it again when the modification
+// time of its files changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate. When the changed files
+// can't be loaded (e.g. while only one of them is replaced), the previous
+// certificate is used.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			if r.cert != nil {
+				log.Printf("Error reading TLS certificate, using the previous one: %v", err)
+				return r.cert, nil
+			}
+			return nil, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			log.Printf("Error loading TLS certificate, using the previous one: %v", err)
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+	if r.cert != nil {
+		log.Printf("Loaded changed TLS certificate %s", r.certFile)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return r.cert, nil
+}
+
+// maxCachedAuths is the maximum number of successful authentications kept in
+// an authCache.
+const maxCachedAuths = 100
+
+// authCache holds the successful basic authentications, so that bcrypt (which
+// is slow on purpose) only runs for the first request with a password. The
+// entries are keyed by a hash of the user, the bcrypt hash and the password,
+// so that the passwords aren't kept in memory.
+type authCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]bool
+}
+
+func authCacheKey(user, hash, password string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + password))
+}
+
+func (c *authCache) get(key [sha256.Size]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+// add adds key to the cache, dropping a random entry when it's full.
+func (c *authCache) add(key [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedAuths {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = true
+}
+
+// basicAuth returns a handler that only lets the users in c through to
+// handler.
+func (c *webConfig) basicAuth(handler http.Handler) http.Handler {
+	// The passwords of unknown users are compared with dummyHash, so that
+	// they take as long to reject as wrong passwords.
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("opensips_exporter"), bcrypt.DefaultCost)
+	cache := &authCache{entries: make(map[[sha256.Size]byte]bool)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok {
+			hash, known := c.BasicAuthUsers[user]
+			if !known {
+				hash = string(dummyHash)
+			}
+			key := authCacheKey(user, hash, password)
+			if known && cache.get(key) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+			if known && err == nil {
+				cache.add(key)
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="OpenSIPS Exporter"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// shutdownTimeout is how long the requests being served get to finish on
+// shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// listen serves handler on addr, with the TLS and authentication settings of
+// the web config file when it's set. When ctx is done the listener is shut
+// down, and listen returns once the requests being served have finished.
+func listen(ctx context.Context, addr, webConfigFile string, handler http.Handler) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	if webConfigFile != "" {
+		c, err := loadWebConfig(webConfigFile)
+		if err != nil {
+			return fmt.Errorf("invalid -web_config_file: %w", err)
+		}
+		if len(c.BasicAuthUsers) > 0 {
+			server.Handler = c.basicAuth(handler)
+		}
+		if c.TLSServerConfig != nil {
+			server.TLSConfig, err = c.TLSServerConfig.tlsConfig()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	var err error
+	if server.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate.
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return <-shutdown
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &webConfig{BasicAuthUsers: map[string]string{"prometheus": string(hash)}}
+	handler := c.basicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}))
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		noAuth   bool
+		expected int
+	}{
+		{name: "valid", user: "prometheus", password: "secret", expected: http.StatusOK},
+		{name: "valid again", user: "prometheus", password: "secret", expected: http.StatusOK},
+		{name: "wrong password", user: "prometheus", password: "guess", expected: http.StatusUnauthorized},
+		{name: "empty password", user: "prometheus", expected: http.StatusUnauthorized},
+		{name: "unknown user", user: "grafana", password: "secret", expected: http.StatusUnauthorized},
+		{name: "unknown user with the dummy password", user: "grafana", password: "opensips_exporter", expected: http.StatusUnauthorized},
+		{name: "no credentials", noAuth: true, expected: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if !tt.noAuth {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestAuthCache(t *testing.T) {
+	c := &authCache{entries: make(map[[32]byte]bool)}
+	key := authCacheKey("prometheus", "$2y$10$hash", "secret")
+	if c.get(key) {
+		t.Fatal("expected an empty cache")
+	}
+	c.add(key)
+	if !c.get(key) {
+		t.Error("expected the authentication to be cached")
+	}
+	for _, other := range [][32]byte{
+		authCacheKey("prometheus", "$2y$10$hash", "guess"),
+		authCacheKey("prometheus", "$2y$10$other", "secret"),
+		authCacheKey("grafana", "$2y$10$hash", "secret"),
+	} {
+		if c.get(other) {
+			t.Error("expected only the cached user, hash and password to match")
+		}
+	}
+	for i := 0; i < 2*maxCachedAuths; i++ {
+		c.add(authCacheKey("prometheus", "$2y$10$hash", string(rune('a'+i))))
+	}
+	if len(c.entries) != maxCachedAuths {
+		t.Errorf("expected the cache to hold at most %d entries, got %d", maxCachedAuths, len(c.entries))
+	}
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"empty", "", true},
+		{"basic auth", "basic_auth_users:\n  prometheus: $2y$10$X0h1gDsPszWURQaxFh.zoubFi6DXncSjhoQNJgRrnGs7EsimhC7zG\n", true},
+		{"invalid hash", "basic_auth_users:\n  prometheus: secret\n", false},
+		{"tls", "tls_server_config:\n  cert_file: tls.crt\n  key_file: tls.key\n", true},
+		{"tls without key", "tls_server_config:\n  cert_file: tls.crt\n", false},
+		{"client ca", "tls_server_config:\n  cert_file: tls.crt\n  key_file: tls.key\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: ca.crt\n", true},
+		{"verify without client ca", "tls_server_config:\n  cert_file: tls.crt\n  key_file: tls.key\n  client_auth_type: RequireAndVerifyClientCert\n", false},
+		{"unknown client auth type", "tls_server_config:\n  cert_file: tls.crt\n  key_file: tls.key\n  client_auth_type: Always\n", false},
+		{"unknown field", "basic_auth:\n  prometheus: secret\n", false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "web.yml")
+			if err := ioutil.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadWebConfig(path)
+			if (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, got error %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+// testCert is a certificate and its key, signed by parent (or self-signed
+// when parent is nil).
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, name string, ca bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// write writes the certificate and key to dir, with the given modification
+// time.
+func (c *testCert) write(t *testing.T, dir string, modTime time.Time) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	for file, content := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM} {
+		if err := ioutil.WriteFile(file, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, "first", false, nil)
+	certFile, keyFile := first.write(t, dir, time.Now().Add(-time.Minute))
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	serial := func() *big.Int {
+		t.Helper()
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.SerialNumber
+	}
+	if s := serial(); s.Cmp(first.cert.SerialNumber) != 0 {
+		t.Fatalf("expected the first certificate, got serial %v", s)
+	}
+
+	// A half written certificate doesn't replace the loaded one.
+	if err := ioutil.WriteFile(certFile, []byte("-----BEGIN CERTIFICATE-----\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if s := serial(); s.Cmp(first.cert.SerialNumber) != 0 {
+		t.Errorf("expected the first certificate after a broken update, got serial %v", s)
+	}
+
+	second := newTestCert(t, "second", false, nil)
+	second.write(t, dir, time.Now().Add(time.Minute))
+	if s := serial(); s.Cmp(second.cert.SerialNumber) != 0 {
+		t.Errorf("expected the second certificate after the update, got serial %v", s)
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if s := serial(); s.Cmp(second.cert.SerialNumber) != 0 {
+		t.Errorf("expected the second certificate after removing the key, got serial %v", s)
+	}
+}
+
+func TestClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", true, nil)
+	otherCA := newTestCert(t, "other ca", true, nil)
+	server := newTestCert(t, "server", false, ca)
+	certFile, keyFile := server.write(t, dir, time.Now())
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, ca.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := (&tlsServerConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientAuthType: "RequireAndVerifyClientCert",
+		ClientCAFile:   caFile,
+	}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	})}
+	go s.Serve(l)
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tests := []struct {
+		name   string
+		client *testCert
+		valid  bool
+	}{
+		{"signed by the client CA", newTestCert(t, "prometheus", false, ca), true},
+		{"signed by another CA", newTestCert(t, "prometheus", false, otherCA), false},
+		{"self-signed", newTestCert(t, "prometheus", false, nil), false},
+		{"without certificate", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := &tls.Config{RootCAs: roots}
+			if tt.client != nil {
+				cert, err := tls.X509KeyPair(tt.client.certPEM, tt.client.keyPEM)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clientConfig.Certificates = []tls.Certificate{cert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+			resp, err := client.Get("https://" + l.Addr().String() + "/metrics")
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, got error %v", tt.valid, err)
+			}
+		})
+	}
+}